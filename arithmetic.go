@@ -0,0 +1,395 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+
+const (
+    ARITHMETIC_OPEN  = "(("
+    ARITHMETIC_CLOSE = "))"
+
+    ARITHMETIC_NUMBER  = 'n'
+    ARITHMETIC_OPERAND = 'v'
+
+    ARITHMETIC_MAX = int64(^uint64(0) >> 1)
+    ARITHMETIC_MIN = -ARITHMETIC_MAX - 1
+)
+
+
+// scriptArithmetic is the node of arithmetic expression, op is either one of
+// "+-*/%", or ARITHMETIC_NUMBER for the number, or ARITHMETIC_OPERAND for
+// the variable reference.
+type scriptArithmetic struct {
+    op        byte
+    left     *scriptArithmetic
+    right    *scriptArithmetic
+    value     int64
+    operand  *ComplexValue
+    text      string
+}
+
+
+func (p *parser) skipBlanks() {
+    for p.pos < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.pos]) >= 0 {
+        p.pos++
+    }
+}
+
+
+func (p *parser) errArithmetic() error {
+    if p.pos == len(p.text) {
+        err := fmt.Errorf("unexpected end of string, \"%s\" is missing",
+                          ARITHMETIC_CLOSE)
+
+        return p.fail(newParseError(ErrUnterminated, err), p.open.from,
+                      p.open.to)
+    }
+
+    err := fmt.Errorf("unexpected character '%c' in arithmetic expression",
+                      p.text[p.pos])
+
+    return p.fail(err, p.pos, p.pos)
+}
+
+
+// parseArithmetic parses the arithmetic expression, like $(( $port + 1 )),
+// the preface is consumed, opened reports whether the left bracket is
+// consumed with the preface, in such a case, the right bracket must follow
+// the expression.
+func (p *parser) parseArithmetic(cv *ComplexValue, opened bool) error {
+    from := p.pos - len(p.syntax.opener())
+
+    // skips the "(("
+    p.pos += len(ARITHMETIC_OPEN)
+
+    open := p.open
+    p.open = span { from, p.pos }
+
+    kept := p.kept
+    p.kept = false
+
+    expr, err := p.parseExpression()
+    if err != nil {
+        return err
+    }
+
+    // an unknown variable is kept in the operands
+    unknown := p.kept
+    p.kept = p.kept || kept
+
+    p.skipBlanks()
+
+    if p.hasPrefix(ARITHMETIC_CLOSE) == false {
+        return p.errArithmetic()
+    }
+
+    p.pos += len(ARITHMETIC_CLOSE)
+
+    if opened {
+        if p.atRBracket() == false {
+            err := fmt.Errorf("\"%s\" for arithmetic expression is missing",
+                              p.syntax.RBracket)
+
+            return p.fail(newParseError(ErrUnterminated, err), p.pos, p.pos)
+        }
+
+        p.pos += len(p.syntax.RBracket)
+    }
+
+    p.open = open
+
+    if unknown {
+        return p.keepReference(cv, from)
+    }
+
+    cv.code = append(cv.code, scriptCode {
+        kind       : SCRIPT_ARITHMETIC,
+        data       : p.text[from:p.pos],
+        arithmetic : expr,
+    })
+
+    cv.size++
+
+    return nil
+}
+
+
+// parseExpression parses the sum, i.e. the terms separated by '+' or '-'.
+func (p *parser) parseExpression() (*scriptArithmetic, error) {
+    left, err := p.parseTerm()
+    if err != nil {
+        return nil, err
+    }
+
+    for {
+        p.skipBlanks()
+
+        if p.pos == len(p.text) {
+            return left, nil
+        }
+
+        op := p.text[p.pos]
+        if op != '+' && op != '-' {
+            return left, nil
+        }
+
+        p.pos++
+
+        right, err := p.parseTerm()
+        if err != nil {
+            return nil, err
+        }
+
+        left = &scriptArithmetic {
+            op    : op,
+            left  : left,
+            right : right,
+        }
+    }
+}
+
+
+// parseTerm parses the product, i.e. the factors separated by '*', '/' or
+// '%'.
+func (p *parser) parseTerm() (*scriptArithmetic, error) {
+    left, err := p.parseFactor()
+    if err != nil {
+        return nil, err
+    }
+
+    for {
+        p.skipBlanks()
+
+        if p.pos == len(p.text) {
+            return left, nil
+        }
+
+        op := p.text[p.pos]
+        if op != '*' && op != '/' && op != '%' {
+            return left, nil
+        }
+
+        p.pos++
+
+        right, err := p.parseFactor()
+        if err != nil {
+            return nil, err
+        }
+
+        left = &scriptArithmetic {
+            op    : op,
+            left  : left,
+            right : right,
+        }
+    }
+}
+
+
+// parseFactor parses a number, a variable reference(with or without the
+// preface), a parenthesized expression, or a signed factor, the nesting
+// depth is limited like the variables.
+func (p *parser) parseFactor() (*scriptArithmetic, error) {
+    if p.depth == VARIABLE_MAX_DEPTH {
+        return nil, p.fail(errors.New("too deep nested expression"), p.pos,
+                           p.pos)
+    }
+
+    p.depth++
+    factor, err := p.parseOperand()
+    p.depth--
+
+    return factor, err
+}
+
+
+func (p *parser) parseOperand() (*scriptArithmetic, error) {
+    p.skipBlanks()
+
+    if p.pos == len(p.text) || p.hasPrefix(ARITHMETIC_CLOSE) {
+        return nil, p.errArithmetic()
+    }
+
+    from := p.pos
+    ch := p.text[p.pos]
+
+    switch {
+
+    case ch == '+' || ch == '-':
+        p.pos++
+
+        operand, err := p.parseFactor()
+        if err != nil {
+            return nil, err
+        }
+
+        // -x is treated as 0 - x
+        return &scriptArithmetic {
+            op    : ch,
+            left  : &scriptArithmetic { op : ARITHMETIC_NUMBER },
+            right : operand,
+        }, nil
+
+    case ch == FILTER_LPAREN:
+        p.pos++
+
+        expr, err := p.parseExpression()
+        if err != nil {
+            return nil, err
+        }
+
+        p.skipBlanks()
+
+        if p.pos == len(p.text) || p.text[p.pos] != FILTER_RPAREN {
+            return nil, p.errArithmetic()
+        }
+
+        p.pos++
+
+        return expr, nil
+
+    case isDigit(ch):
+        for p.pos < len(p.text) && isDigit(p.text[p.pos]) {
+            p.pos++
+        }
+
+        n, err := strconv.ParseInt(p.text[from:p.pos], 10, 64)
+        if err != nil {
+            return nil, p.fail(fmt.Errorf("too large number \"%s\"",
+                                          p.text[from:p.pos]),
+                               from, p.pos)
+        }
+
+        return &scriptArithmetic {
+            op    : ARITHMETIC_NUMBER,
+            value : n,
+        }, nil
+
+    case p.atOpener():
+        operand := p.corgi.newComplexValue()
+
+        if err := p.parseVariable(operand); err != nil {
+            return nil, err
+        }
+
+        return &scriptArithmetic {
+            op      : ARITHMETIC_OPERAND,
+            operand : operand,
+            text    : p.text[from:p.pos],
+        }, nil
+
+    case isValidVariableCharacter(rune(ch)):
+        // the bare name, like $(( port + 1 ))
+        operand := p.corgi.newComplexValue()
+        name := p.parseName()
+
+        if err := operand.append(name, true); err != nil {
+            if err := p.unknownVariable(operand, err, from, from,
+                                        name); err != nil {
+                return nil, err
+            }
+        }
+
+        return &scriptArithmetic {
+            op      : ARITHMETIC_OPERAND,
+            operand : operand,
+            text    : name,
+        }, nil
+    }
+
+    return nil, p.errArithmetic()
+}
+
+
+// arithmeticValue evaluates the arithmetic expression, the integer overflow
+// and the division by zero fail the evaluation.
+func (c *coder) arithmeticValue(node *scriptArithmetic) (int64, error) {
+    switch node.op {
+
+    case ARITHMETIC_NUMBER:
+        return node.value, nil
+
+    case ARITHMETIC_OPERAND:
+        value, err := c.codeString(node.operand)
+        if err != nil {
+            return 0, err
+        }
+
+        n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+        if err != nil {
+            return 0, fmt.Errorf("value \"%s\" of \"%s\" is not an integer",
+                                 value, node.text)
+        }
+
+        return n, nil
+    }
+
+    left, err := c.arithmeticValue(node.left)
+    if err != nil {
+        return 0, err
+    }
+
+    right, err := c.arithmeticValue(node.right)
+    if err != nil {
+        return 0, err
+    }
+
+    return arithmeticApply(node.op, left, right)
+}
+
+
+func arithmeticApply(op byte, left, right int64) (int64, error) {
+    overflow := false
+
+    switch op {
+
+    case '+':
+        overflow = (right > 0 && left > ARITHMETIC_MAX - right) ||
+                   (right < 0 && left < ARITHMETIC_MIN - right)
+
+        if overflow == false {
+            return left + right, nil
+        }
+
+    case '-':
+        overflow = (right < 0 && left > ARITHMETIC_MAX + right) ||
+                   (right > 0 && left < ARITHMETIC_MIN + right)
+
+        if overflow == false {
+            return left - right, nil
+        }
+
+    case '*':
+        result := left * right
+
+        overflow = (left != 0 && result / left != right) ||
+                   (left == -1 && right == ARITHMETIC_MIN) ||
+                   (right == -1 && left == ARITHMETIC_MIN)
+
+        if overflow == false {
+            return result, nil
+        }
+
+    case '/', '%':
+        if right == 0 {
+            return 0, errors.New("division by zero")
+        }
+
+        if left == ARITHMETIC_MIN && right == -1 {
+            break
+        }
+
+        if op == '/' {
+            return left / right, nil
+        }
+
+        return left % right, nil
+    }
+
+    return 0, fmt.Errorf("integer overflow: %d %c %d", left, op, right)
+}
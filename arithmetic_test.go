@@ -0,0 +1,120 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "os"
+    "strings"
+    "testing"
+)
+
+
+func testArithmeticValue(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    os.Setenv("CORGI_ARITH_PORT", "8080")
+    os.Setenv("CORGI_ARITH_CPUS", " 4 ")
+
+    c.Group = []string { "", "3" }
+
+    cases := map[string]string {
+        "$(( $env_CORGI_ARITH_PORT + 1 ))"              : "8081",
+        "$((env_CORGI_ARITH_PORT-80))"                  : "8000",
+        "workers=$(( ${env_CORGI_ARITH_CPUS} * 2 ))"    : "workers=8",
+        "$((1 + 2 * 3))"                                : "7",
+        "$(( (1 + 2) * 3 ))"                            : "9",
+        "$((10 - 4 - 3))"                               : "3",
+        "$((100 / 10 / 5))"                             : "2",
+        "$((-7 / 2)) $((-7 % 3)) $((7 % -3))"           : "-3 -1 1",
+        "$((- -1 + +2))"                                : "3",
+        "$(( $1 * ${unset:-4} ))"                       : "12",
+        "$(( $(( 1 + 1 )) * 3 ))"                       : "6",
+        "$((\n\t1 +\n\t2\n))"                           : "3",
+        "$$((1))"                                       : "$((1))",
+        "$((9223372036854775807))"                      : "9223372036854775807",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    cases = map[string]string {
+        "$(( $set + 1 ))"                   : "value \"value\" of \"$set\" is not an integer",
+        "$(( ${null} + 1 ))"                : "value \"\" of \"${null}\" is not an integer",
+        "$((1 / 0))"                        : "division by zero",
+        "$((1 % (2 - 2)))"                  : "division by zero",
+        "$((9223372036854775807 + 1))"      : "integer overflow: 9223372036854775807 + 1",
+        "$((0 - 9223372036854775807 - 2))"  : "integer overflow: -9223372036854775807 - 2",
+        "$((4611686018427387904 * 2))"      : "integer overflow: 4611686018427387904 * 2",
+        "$(((0 - 9223372036854775807 - 1) / -1))" :
+            "integer overflow: -9223372036854775808 / -1",
+    }
+
+    for text, errorReason := range cases {
+        cv, err := c.Parse(text)
+        if err != nil {
+            t.Fatalf("failed to parse \"%s\": %s", text, err.Error())
+        }
+
+        if _, err := c.Code(cv); err == nil {
+            t.Fatalf("unexpected successful coding of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testArithmeticParseFailed(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "$((1 +))"            : "unexpected character ')' in arithmetic expression",
+        "$(( ))"              : "unexpected character ')' in arithmetic expression",
+        "$((1 2))"            : "unexpected character '2' in arithmetic expression",
+        "$((1 + (2"           : "unexpected end of string, \"))\" is missing",
+        "$((1"                : "unexpected end of string, \"))\" is missing",
+        "$((xxxxx + 1))"      : "unknown variable \"xxxxx\"",
+        "$(($xxxxx + 1))"     : "unknown variable \"xxxxx\"",
+        "$((99999999999999999999))" : "too large number \"99999999999999999999\"",
+        "$((" + strings.Repeat("(", 64) + "1" + strings.Repeat(")", 64) + "))" :
+            "too deep nested expression",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    c, err := New(WithSyntax(MustacheSyntax))
+    if err != nil {
+        t.Fatalf("failed to create corgi instance: %s", err.Error())
+    }
+
+    if data := parse(t, c, "{{(( 6 * 7 ))}}"); data != "42" {
+        t.Fatalf("incorrect value, expected \"42\" but seen \"%s\"", data)
+    }
+
+    errorReason := "\"}}\" for arithmetic expression is missing"
+
+    if _, err := c.Parse("{{((1))"); err == nil {
+        t.Fatal("unexpected successful parsing")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func TestArithmetic(t *testing.T) {
+    testArithmeticValue(t)
+    testArithmeticParseFailed(t)
+}
@@ -0,0 +1,242 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "sync"
+    "sync/atomic"
+)
+
+
+const (
+    CACHE_SHARDS = 16
+)
+
+
+// variableCache caches the variables values, which is sharded by the hash
+// of name, so that the concurrent codings rarely contend for the same lock.
+type variableCache struct {
+    shards  [CACHE_SHARDS]cacheShard
+}
+
+
+type cacheShard struct {
+    lock     sync.RWMutex
+    values   map[string]*VariableValue
+}
+
+
+// indexedValue is the cached value of the indexed variable, which is valid
+// only in generation, so registering any variable invalidates all the indexed
+// values, that's fine since the variables are rarely registered after the
+// templates are parsed.
+type indexedValue struct {
+    value      *VariableValue
+    generation  uint64
+}
+
+
+func (cache *variableCache) init() {
+    for i := range cache.shards {
+        cache.shards[i].values = make(map[string]*VariableValue,
+                                      VARIABLE_SLOTS / CACHE_SHARDS)
+    }
+}
+
+
+// shard returns the shard of name, by the FNV-1a hash.
+func (cache *variableCache) shard(name string) *cacheShard {
+    var hash uint32 = 2166136261
+
+    for i := 0; i < len(name); i++ {
+        hash ^= uint32(name[i])
+        hash *= 16777619
+    }
+
+    return &cache.shards[hash % CACHE_SHARDS]
+}
+
+
+func (cache *variableCache) get(name string) (*VariableValue, bool) {
+    shard := cache.shard(name)
+
+    shard.lock.RLock()
+    value, ok := shard.values[name]
+    shard.lock.RUnlock()
+
+    return value, ok
+}
+
+
+// flush removes the cached values whose names are matched.
+func (cache *variableCache) flush(match func(name string) bool) {
+    for i := range cache.shards {
+        shard := &cache.shards[i]
+
+        shard.lock.Lock()
+
+        for name, _ := range shard.values {
+            if match(name) {
+                delete(shard.values, name)
+            }
+        }
+
+        shard.lock.Unlock()
+    }
+}
+
+
+func (corgi *Corgi) currentGeneration() uint64 {
+    return atomic.LoadUint64(&corgi.generation)
+}
+
+
+// growSlots makes the slots of the indexed variables hold at least n values,
+// the caller must hold the lock. The values are not copied, since they are
+// invalidated by the registering.
+func (corgi *Corgi) growSlots(n int) {
+    if n <= len(corgi.indexedSlots()) {
+        return
+    }
+
+    size := VARIABLE_SLOTS
+
+    for size < n {
+        size <<= 1
+    }
+
+    corgi.slots.Store(make([]atomic.Value, size))
+}
+
+
+func (corgi *Corgi) indexedSlots() []atomic.Value {
+    slots, _ := corgi.slots.Load().([]atomic.Value)
+    return slots
+}
+
+
+// indexedGet gets the cached value of the indexed variable index, which is
+// got in generation.
+func (corgi *Corgi) indexedGet(index int,
+                               generation uint64) (*VariableValue, bool) {
+
+    slots := corgi.indexedSlots()
+    if index >= len(slots) {
+        return nil, false
+    }
+
+    cached, _ := slots[index].Load().(*indexedValue)
+    if cached == nil || cached.generation != generation ||
+       generation != corgi.currentGeneration() {
+
+        return nil, false
+    }
+
+    return cached.value, true
+}
+
+
+func (corgi *Corgi) indexedSet(index int, value *VariableValue,
+                               generation uint64) {
+
+    slots := corgi.indexedSlots()
+    if index >= len(slots) {
+        return
+    }
+
+    slots[index].Store(&indexedValue {
+        value      : value,
+        generation : generation,
+    })
+}
+
+
+// cacheValue caches the value of name, which is got when the generation is
+// generation, it is dropped if a variable is registered after that, since
+// the value may be got by the replaced variable.
+func (corgi *Corgi) cacheValue(name string, value *VariableValue,
+                               generation uint64) {
+
+    shard := corgi.caches.shard(name)
+
+    shard.lock.Lock()
+
+    if corgi.currentGeneration() == generation {
+        shard.values[name] = value
+    }
+
+    shard.lock.Unlock()
+}
+
+
+// cached gets the cached value of the variable, by index if it's indexed, or
+// by name, from the session if any.
+func (c *coder) cached(index int, name string,
+                       generation uint64) (*VariableValue, bool) {
+
+    if c.caches == nil {
+        if value, ok := c.assigned[name]; ok {
+            return value, true
+        }
+
+        if index >= 0 {
+            return c.corgi.indexedGet(index, generation)
+        }
+
+        return c.corgi.caches.get(name)
+    }
+
+    if index < 0 {
+        value, ok := c.caches[name]
+        return value, ok
+    }
+
+    if index < len(c.indexed) && c.indexed[index] != nil {
+        return c.indexed[index], true
+    }
+
+    return nil, false
+}
+
+
+func (c *coder) cache(index int, name string, value *VariableValue,
+                      generation uint64) {
+
+    if c.caches == nil {
+        if index >= 0 {
+            c.corgi.indexedSet(index, value, generation)
+            return
+        }
+
+        c.corgi.cacheValue(name, value, generation)
+        return
+    }
+
+    if index < 0 {
+        c.caches[name] = value
+        return
+    }
+
+    for index >= len(c.indexed) {
+        c.indexed = append(c.indexed, nil)
+    }
+
+    c.indexed[index] = value
+}
+
+
+// assign caches the value assigned to the variable, for the session if any,
+// or for the coding, it's never shared with the other codings, since the
+// variable may be not cacheable.
+func (c *coder) assign(index int, name string, value *VariableValue) {
+    if c.caches != nil {
+        c.cache(index, name, value, c.corgi.currentGeneration())
+        return
+    }
+
+    if c.assigned == nil {
+        c.assigned = make(map[string]*VariableValue)
+    }
+
+    c.assigned[name] = value
+}
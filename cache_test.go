@@ -0,0 +1,263 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+
+const (
+    INDEXED_VARIABLES = 24
+)
+
+
+type countContext struct {
+    calls    int
+}
+
+
+func variableGetCounted(value *VariableValue, ctx interface{},
+                        name string) error {
+
+    if counter, ok := ctx.(*countContext); ok {
+        counter.calls++
+    }
+
+    value.NotFound = false
+    value.Cacheable = true
+    value.Value = name
+
+    return nil
+}
+
+
+func variableGetCountedPath(value *VariableValue, ctx interface{},
+                            name string, path []string) error {
+
+    if err := variableGetCounted(value, ctx, name); err != nil {
+        return err
+    }
+
+    value.Value += "." + strings.Join(path, ".")
+
+    return nil
+}
+
+
+// indexedTemplate refers to INDEXED_VARIABLES variables, like "${prefix}var0".
+func indexedTemplate(prefix string) string {
+    var names    []string
+
+    for i := 0; i < INDEXED_VARIABLES; i++ {
+        names = append(names, fmt.Sprintf("$%svar%d", prefix, i))
+    }
+
+    return strings.Join(names, " ")
+}
+
+
+// newIndexedCorgi registers INDEXED_VARIABLES variables, like "var0", and
+// parses the template which refers to all of them. The same variables are
+// also registered under the prefix "named_" of unknown variable, which are
+// cached by the names, as the baseline.
+func newIndexedCorgi(tb testing.TB) (*Corgi, *ComplexValue) {
+    c, err := New()
+    if err != nil {
+        tb.Fatal("failed to create corgi instance failed")
+    }
+
+    for i := 0; i < INDEXED_VARIABLES; i++ {
+        variable := &Variable {
+            Name    : fmt.Sprintf("var%d", i),
+            Get     : variableGetCounted,
+            GetPath : variableGetCountedPath,
+            Flags   : VARIABLE_CHANGEABLE,
+        }
+
+        if err := c.RegisterNewVariable(variable); err != nil {
+            tb.Fatalf("failed to register new variable: %s", err.Error())
+        }
+    }
+
+    named := &Variable {
+        Name  : "named_",
+        Get   : variableGetCounted,
+        Flags : VARIABLE_UNKNOWN,
+    }
+
+    if err := c.RegisterNewVariable(named); err != nil {
+        tb.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse(indexedTemplate(""))
+    if err != nil {
+        tb.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    return c, cv
+}
+
+
+func testCacheIndexes(t *testing.T) {
+    c, cv := newIndexedCorgi(t)
+
+    _, _, first := c.lookupVariable("var0")
+    _, _, last := c.lookupVariable(fmt.Sprintf("var%d", INDEXED_VARIABLES - 1))
+
+    if last - first != INDEXED_VARIABLES - 1 {
+        t.Fatalf("unexpected indexes %d and %d", first, last)
+    }
+
+    if _, _, index := c.lookupVariable("arg_id"); index != -1 {
+        t.Fatalf("unexpected index %d of unknown variable", index)
+    }
+
+    if index := cv.code[0].ref.bound(c, "var0").index; index != first {
+        t.Fatalf("unexpected index %d of reference", index)
+    }
+
+    variable := &Variable {
+        Name : "var0",
+        Get  : variableGetVersion("v1"),
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    // the index is kept
+    if _, _, index := c.lookupVariable("var0"); index != first {
+        t.Fatalf("index %d is changed to %d", first, index)
+    }
+
+    if data, err := c.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if strings.HasPrefix(data, "v1 var1 ") == false {
+        t.Fatalf("incorrect value \"%s\"", data)
+    }
+}
+
+
+func testCacheIndexed(t *testing.T) {
+    c, _ := newIndexedCorgi(t)
+
+    counter := &countContext {}
+    c.Context = counter
+
+    cv, err := c.Parse("$var0 ${var1} ${var0.x} ${var0.x} $var0")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    expected := "var0 var1 var0.x var0.x var0"
+
+    expectCode(t, c, cv, expected)
+
+    if counter.calls != 3 {
+        t.Fatalf("unexpected calls of get handler: %d", counter.calls)
+    }
+
+    counter.calls = 0
+
+    expectCode(t, c, cv, expected)
+
+    // the indexed values and the paths are cached
+    if counter.calls != 0 {
+        t.Fatalf("unexpected calls of get handler: %d", counter.calls)
+    }
+
+    // registering a variable invalidates the indexed values
+    if err := c.RegisterNewVariable(&Variable {
+        Name : "other",
+        Get  : variableGetCounted,
+    }); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    counter.calls = 0
+
+    expectCode(t, c, cv, expected)
+
+    // the paths are still cached by the names
+    if counter.calls != 2 {
+        t.Fatalf("unexpected calls of get handler: %d", counter.calls)
+    }
+
+    session := c.NewSession(counter)
+    defer session.Release()
+
+    counter.calls = 0
+
+    sessionCode(t, session, cv, expected)
+    sessionCode(t, session, cv, expected)
+
+    if counter.calls != 3 {
+        t.Fatalf("unexpected calls of get handler: %d", counter.calls)
+    }
+}
+
+
+func TestCache(t *testing.T) {
+    testCacheIndexes(t)
+    testCacheIndexed(t)
+}
+
+
+// benchmarkIndexed codes the template which refers to the indexed variables,
+// and the baseline one which refers to the same number of variables by name.
+func benchmarkIndexed(b *testing.B, c *Corgi, cv *ComplexValue,
+                      code func([]byte, *ComplexValue) ([]byte, error)) {
+
+    named, err := c.Parse(indexedTemplate("named_"))
+    if err != nil {
+        b.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    templates := []struct {
+        name  string
+        cv   *ComplexValue
+    } {
+        { "index", cv },
+        { "name", named },
+    }
+
+    for _, template := range templates {
+        cv := template.cv
+
+        b.Run(template.name, func(b *testing.B) {
+            dst := make([]byte, 0, 512)
+
+            b.ReportAllocs()
+            b.ResetTimer()
+
+            for i := 0; i < b.N; i++ {
+                var err error
+
+                if dst, err = code(dst[:0], cv); err != nil {
+                    b.Fatal(err.Error())
+                }
+            }
+        })
+    }
+}
+
+
+func BenchmarkIndexedCode(b *testing.B) {
+    c, cv := newIndexedCorgi(b)
+
+    benchmarkIndexed(b, c, cv, c.AppendCode)
+}
+
+
+func BenchmarkIndexedSession(b *testing.B) {
+    c, cv := newIndexedCorgi(b)
+
+    session := c.NewSession(nil)
+    defer session.Release()
+
+    benchmarkIndexed(b, c, cv, session.AppendCode)
+}
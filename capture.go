@@ -0,0 +1,174 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "errors"
+    "regexp"
+    "strconv"
+)
+
+
+const (
+    CAPTURE_LANGLE = '<'
+    CAPTURE_RANGLE = '>'
+)
+
+
+// BindRegexp matches subject with re, the capture groups will be referenced
+// by the templates, both the numeric ones(like $1 and ${2}) and the named
+// ones(like ${<host>}, or $host if there is no variable with the same name).
+// The groups are cleared if subject is not matched.
+// The result reports whether subject is matched.
+func (corgi *Corgi) BindRegexp(re *regexp.Regexp, subject string) bool {
+    group := re.FindStringSubmatch(subject)
+
+    corgi.bindGroup(re, group)
+
+    return group != nil
+}
+
+
+// BindRegexpBytes is like BindRegexp, but subject is a byte slice.
+func (corgi *Corgi) BindRegexpBytes(re *regexp.Regexp, subject []byte) bool {
+    group := findSubmatch(re, subject)
+
+    corgi.bindGroup(re, group)
+
+    return group != nil
+}
+
+
+// findSubmatch is like regexp.FindStringSubmatch, but subject is a byte
+// slice.
+func findSubmatch(re *regexp.Regexp, subject []byte) []string {
+    var group []string
+
+    if match := re.FindSubmatch(subject); match != nil {
+        group = make([]string, len(match))
+
+        for i, value := range match {
+            group[i] = string(value)
+        }
+    }
+
+    return group
+}
+
+
+// bindGroup binds the capture groups, the names of groups are remembered,
+// so that the later parsed templates can refer to them like $host.
+func (corgi *Corgi) bindGroup(re *regexp.Regexp, group []string) {
+    groupNames := make(map[string]int)
+
+    for i, name := range re.SubexpNames() {
+        if name != "" {
+            groupNames[name] = i
+        }
+    }
+
+    corgi.Group = group
+
+    corgi.lock.Lock()
+
+    corgi.groupNames = groupNames
+
+    for name, _ := range groupNames {
+        corgi.captureNames[name] = true
+    }
+
+    corgi.lock.Unlock()
+}
+
+
+// groupIndex returns the index of the named group of the bound regular
+// expression.
+func (corgi *Corgi) groupIndex(name string) (int, bool) {
+    corgi.lock.RLock()
+    index, ok := corgi.groupNames[name]
+    corgi.lock.RUnlock()
+
+    return index, ok
+}
+
+
+// groupIndex returns the index of the named group, of the regular expression
+// bound by the session if any.
+func (c *coder) groupIndex(name string) (int, bool) {
+    if c.regexp == nil {
+        return c.corgi.groupIndex(name)
+    }
+
+    for i, groupName := range c.regexp.SubexpNames() {
+        if groupName == name && i > 0 {
+            return i, true
+        }
+    }
+
+    return 0, false
+}
+
+
+// isCaptureGroup reports whether name is a group name ever bound.
+func (corgi *Corgi) isCaptureGroup(name string) bool {
+    corgi.lock.RLock()
+    ok := corgi.captureNames[name]
+    corgi.lock.RUnlock()
+
+    return ok
+}
+
+
+// isCaptureName reports whether name refers to a capture group, i.e. a
+// group number or a group name in angle brackets.
+func isCaptureName(name string) bool {
+    if _, err := strconv.Atoi(name); err == nil {
+        return true
+    }
+
+    return isNamedCapture(name)
+}
+
+
+func isNamedCapture(name string) bool {
+    return len(name) > 2 && name[0] == CAPTURE_LANGLE &&
+           name[len(name) - 1] == CAPTURE_RANGLE
+}
+
+
+// captureName returns the canonical name of a capture group, the group name
+// is wrapped by the angle brackets.
+func captureName(name string) string {
+    if isCaptureName(name) {
+        return name
+    }
+
+    return string(CAPTURE_LANGLE) + name + string(CAPTURE_RANGLE)
+}
+
+
+// parseCaptureName parses the named capture group, like "<host>".
+func (p *parser) parseCaptureName() (string, error) {
+    from := p.pos
+
+    // skips the left angle bracket
+    p.pos++
+
+    if p.parseName() == "" || p.pos == len(p.text) ||
+       p.text[p.pos] != CAPTURE_RANGLE {
+
+        return "", p.fail(newParseError(ErrInvalidName,
+                             errors.New("invalid capture group name")),
+                          from, p.pos)
+    }
+
+    // skips the right angle bracket
+    p.pos++
+
+    return p.text[from:p.pos], nil
+}
+
+
+func (p *parser) atCaptureName() bool {
+    return p.pos < len(p.text) && p.text[p.pos] == CAPTURE_LANGLE
+}
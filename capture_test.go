@@ -0,0 +1,113 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "regexp"
+    "testing"
+)
+
+
+func testCaptureBind(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    re := regexp.MustCompile("^/(?P<user>\\w+)/(\\w+)(?:\\.(?P<ext>\\w+))?$")
+
+    if c.BindRegexp(re, "/alex/index.html") == false {
+        t.Fatal("failed to match")
+    }
+
+    cases := map[string]string {
+        "/u/$1/$2"                      : "/u/alex/index",
+        "/u/${<user>}/${<ext>}"         : "/u/alex/html",
+        "/u/$user/$2.$ext"              : "/u/alex/index.html",
+        "${user|upper}"                 : "ALEX",
+        "${<user>:1:2}"                 : "le",
+        "$?{<ext>}{.$ext}"              : ".html",
+        "$?{!ext}{none}{$ext}"          : "html",
+        "${<user>:>6}"                  : "  alex",
+        "$set-$user"                    : "value-alex",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    cv, err := c.Parse("$user${ext:-none}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    // the optional group is not matched
+    if c.BindRegexpBytes(re, []byte("/bob/index")) == false {
+        t.Fatal("failed to match")
+    }
+
+    if data, err := c.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "bobnone" {
+        t.Fatalf("incorrect value, expected \"bobnone\" but seen \"%s\"", data)
+    }
+
+    // the groups are cleared
+    if c.BindRegexp(re, "no match") || c.Group != nil {
+        t.Fatal("unexpected match")
+    }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "empty capture group" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the group "user" does not exist in the current regular expression
+    c.BindRegexp(regexp.MustCompile("(\\d+)"), "123")
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "unknown capture group \"<user>\"" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testCaptureParseFailed(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${<>}"            : "invalid capture group name",
+        "${<user}"         : "invalid capture group name",
+        "${<us er>}"       : "invalid capture group name",
+        "${<user>:=x}"     : "cannot assign to capture group \"<user>\"",
+        "$user"            : "unknown variable \"user\"",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    // the variable wins
+    c.BindRegexp(regexp.MustCompile("(?P<set>\\w+)"), "group")
+
+    if data := parse(t, c, "$set ${<set>}"); data != "value group" {
+        t.Fatalf("incorrect value, expected \"value group\" but seen \"%s\"",
+                 data)
+    }
+}
+
+
+func TestCapture(t *testing.T) {
+    testCaptureBind(t)
+    testCaptureParseFailed(t)
+}
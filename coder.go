@@ -0,0 +1,162 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "sync"
+    "bytes"
+    "regexp"
+    "context"
+)
+
+
+// coder holds the state of a coding, ctx is passed to the handlers of
+// variables and functions, group is the capture groups, resolving is the
+// variables being resolved, which detects the recursive references, context
+// is the one passed to CodeContext, which cancels the coding, assigned is
+// the values assigned by the ":=" operator in the coding.
+// For a session, caches and indexed hold the cached values rather than Corgi,
+// the latter is indexed by the indexes of variables, and
+// regexp is the bound regular expression, whose names of groups are used
+// rather than the ones bound by Corgi.
+type coder struct {
+    corgi      *Corgi
+    ctx         interface{}
+    group       []string
+    resolving   []string
+    context     context.Context
+    assigned    map[string]*VariableValue
+    caches      map[string]*VariableValue
+    indexed     []*VariableValue
+    regexp     *regexp.Regexp
+}
+
+
+var coders = sync.Pool {
+    New : func() interface{} {
+        return new(coder)
+    },
+}
+
+
+// newCoder returns a coder from the pool, each coding has its own coder, so
+// that the concurrent codings do not share the state.
+func (corgi *Corgi) newCoder(ctx interface{}, group []string) *coder {
+    c := coders.Get().(*coder)
+
+    c.corgi = corgi
+    c.ctx = ctx
+    c.group = group
+
+    return c
+}
+
+
+func (c *coder) release() {
+    c.corgi = nil
+    c.ctx = nil
+    c.group = nil
+    c.context = nil
+    c.assigned = nil
+    c.resolving = c.resolving[:0]
+
+    coders.Put(c)
+}
+
+
+// done returns the context passed to the context-aware handlers.
+func (c *coder) done() context.Context {
+    if c.context == nil {
+        return context.Background()
+    }
+
+    return c.context
+}
+
+
+// resolvingKey is the key of the variables being resolved, which are carried
+// by the context passed to the context-aware handlers, so that a handler which
+// codes the templates again with the context(e.g. by CodeContext) continues
+// the resolutions, and the recursive references are detected.
+type resolvingKey struct {}
+
+
+// chained returns the context passed to the context-aware handlers, which
+// carries the variables being resolved.
+func (c *coder) chained() context.Context {
+    resolving := make([]string, len(c.resolving))
+    copy(resolving, c.resolving)
+
+    return context.WithValue(c.done(), resolvingKey {}, resolving)
+}
+
+
+// inherit continues the resolutions carried by ctx, if the coding is started
+// by a context-aware handler.
+func (c *coder) inherit(ctx context.Context) {
+    if resolving, ok := ctx.Value(resolvingKey {}).([]string); ok {
+        c.resolving = append(c.resolving[:0], resolving...)
+    }
+}
+
+
+// stopped checks whether the coding is cancelled, which is done between the
+// segments.
+func (c *coder) stopped() error {
+    if c.context == nil {
+        return nil
+    }
+
+    if err := c.context.Err(); err != nil {
+        return fmt.Errorf("coding is stopped: %w", err)
+    }
+
+    return nil
+}
+
+
+func (c *coder) codeString(cv *ComplexValue) (string, error) {
+    var buffer    bytes.Buffer
+
+    if err := c.code(&buffer, cv); err != nil {
+        return "", err
+    }
+
+    return buffer.String(), nil
+}
+
+
+// CodeWith is like Code, but ctx and groups are used rather than the fields
+// Context and Group, i.e. ctx is passed to the handlers of variables and
+// functions, groups is the capture groups, so the instance is not changed.
+// The named capture groups refer to the names of the regular expression
+// bound by BindRegexp.
+// Note the cached values are still shared by all codings.
+func (corgi *Corgi) CodeWith(cv *ComplexValue, ctx interface{},
+                             groups []string) (string, error) {
+
+    c := corgi.newCoder(ctx, groups)
+    defer c.release()
+
+    return c.codeString(cv)
+}
+
+
+// CodeContext is like Code, but the coding is stopped between the segments
+// once ctx is cancelled or its deadline is exceeded, and ctx is passed to the
+// context-aware get handlers(the GetContext of Variable).
+// The error wraps ctx.Err(), and it names the variable if the coding is
+// cancelled while resolving it, so errors.Is(err, context.DeadlineExceeded)
+// works.
+func (corgi *Corgi) CodeContext(ctx context.Context,
+                                cv *ComplexValue) (string, error) {
+
+    c := corgi.newCoder(corgi.Context, corgi.Group)
+    defer c.release()
+
+    c.context = ctx
+    c.inherit(ctx)
+
+    return c.codeString(cv)
+}
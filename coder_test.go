@@ -0,0 +1,280 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "time"
+    "errors"
+    "regexp"
+    "context"
+    "testing"
+)
+
+
+type requestContext struct {
+    user    string
+}
+
+
+func variableGetUser(value *VariableValue, ctx interface{}, _ string) error {
+    request, ok := ctx.(*requestContext)
+    if ok == false {
+        value.NotFound = true
+        return nil
+    }
+
+    value.NotFound = false
+    value.Cacheable = false
+    value.Value = request.user
+
+    return nil
+}
+
+
+// variableGetSlow waits for the context, unless the data is a duration.
+func variableGetSlow(ctx context.Context, value *VariableValue,
+                     data interface{}, _ string) error {
+
+    wait, ok := data.(time.Duration)
+    if ok == false {
+        <-ctx.Done()
+        return ctx.Err()
+    }
+
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+
+    case <-time.After(wait):
+    }
+
+    value.NotFound = false
+    value.Value = wait.String()
+
+    return nil
+}
+
+
+// variableGetRecursive codes the template again with the context, which
+// carries the variables being resolved.
+func variableGetRecursive(ctx context.Context, value *VariableValue,
+                          data interface{}, _ string) error {
+
+    rc := data.(*recursiveContext)
+
+    result, err := rc.corgi.CodeContext(ctx, rc.cv)
+    if err != nil {
+        return err
+    }
+
+    value.NotFound = false
+    value.Value = result
+
+    return nil
+}
+
+
+func variableGetSleep(value *VariableValue, _ interface{}, _ string) error {
+    time.Sleep(20 * time.Millisecond)
+
+    value.NotFound = false
+    value.Value = "awake"
+
+    return nil
+}
+
+
+func testCoderWith(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variable := &Variable {
+        Name : "user",
+        Get  : variableGetUser,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    re := regexp.MustCompile("^/(?P<page>\\w+)/(\\w+)$")
+
+    if c.BindRegexp(re, "/index/html") == false {
+        t.Fatal("failed to match")
+    }
+
+    c.Context = &requestContext { user : "alex" }
+
+    cv, err := c.Parse("${user:-nobody} $page.$2")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    ctx := &requestContext { user : "bob" }
+    groups := re.FindStringSubmatch("/about/txt")
+
+    if data, err := c.CodeWith(cv, ctx, groups); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "bob about.txt" {
+        t.Fatalf("incorrect value, expected \"bob about.txt\" but seen \"%s\"",
+                 data)
+    }
+
+    // the instance is not changed
+    expectCode(t, c, cv, "alex index.html")
+
+    if data, err := c.CodeWith(cv, nil, groups); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "nobody about.txt" {
+        t.Fatalf("incorrect value, expected \"nobody about.txt\" but seen \"%s\"",
+                 data)
+    }
+
+    if _, err := c.CodeWith(cv, ctx, nil); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "empty capture group" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "alex index.html")
+}
+
+
+func testCoderContext(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variable := &Variable {
+        Name       : "slow",
+        GetContext : variableGetSlow,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse("[${slow}]")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    c.Context = time.Millisecond
+
+    // the handler gets the data from Context
+    expectCode(t, c, cv, "[1ms]")
+
+    if data, err := c.CodeContext(context.Background(), cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "[1ms]" {
+        t.Fatalf("incorrect value, expected \"[1ms]\" but seen \"%s\"", data)
+    }
+
+    // the handler waits until the deadline is exceeded
+    c.Context = nil
+
+    ctx, cancel := context.WithTimeout(context.Background(),
+                                       10 * time.Millisecond)
+    defer cancel()
+
+    _, err = c.CodeContext(ctx, cv)
+    if errors.Is(err, context.DeadlineExceeded) == false {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if err.Error() != "variable \"slow\": context deadline exceeded" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the value got by the handler which ignores the context is dropped
+    variable = &Variable {
+        Name : "sleep",
+        Get  : variableGetSleep,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err = c.Parse("[${sleep}]")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    ctx, cancel = context.WithTimeout(context.Background(),
+                                      10 * time.Millisecond)
+    defer cancel()
+
+    if _, err := c.CodeContext(ctx, cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "variable \"sleep\": context deadline exceeded" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the cancelled coding stops before the first segment
+    ctx, cancel = context.WithCancel(context.Background())
+    cancel()
+
+    cv, err = c.Parse("[$pid]")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if _, err := c.CodeContext(ctx, cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "coding is stopped: context canceled" ||
+              errors.Is(err, context.Canceled) == false {
+
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testCoderRecursive(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    err := c.RegisterNewVariable(&Variable {
+        Name       : "self",
+        GetContext : variableGetRecursive,
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse("${self}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    c.Context = &recursiveContext {
+        corgi : c,
+        cv    : cv,
+    }
+
+    // the handler codes with the context, which detects the recursion
+    errorReason := "variable \"self\" is referenced recursively"
+
+    if _, err := c.CodeContext(context.Background(), cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func TestCoder(t *testing.T) {
+    testCoderWith(t)
+    testCoderRecursive(t)
+    testCoderContext(t)
+}
@@ -0,0 +1,149 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "strconv"
+    "sync/atomic"
+)
+
+
+// scriptReference is the precompiled variable or capture group reference, so
+// that Corgi.Code needs neither the name lookups nor the conversions.
+// For the variable, binding is the resolved one, which is resolved again
+// once it's outdated, since the variables may be registered again.
+// For the capture group, capture is true, group is the group number, or -1
+// for the named group, which depends on the bound regular expression.
+type scriptReference struct {
+    binding     atomic.Value
+    capture     bool
+    group       int
+}
+
+
+// variableBinding is the resolved variable of a reference, variable is the
+// resolved one, name is the name passed to its handlers, path is the
+// splitted path, index is the index of variable, or -1 if the value is cached
+// by name(i.e. the unknown variables and the paths), the binding is valid
+// only if generation is still the one of Corgi.
+type variableBinding struct {
+    variable   *Variable
+    name        string
+    path        []string
+    index       int
+    generation  uint64
+}
+
+
+// compileReference resolves the reference name of kind, the variable may be
+// not registered yet(in late binding mode), which is resolved when coding.
+func (corgi *Corgi) compileReference(kind uint, name string) *scriptReference {
+    if kind == SCRIPT_CAPTURE {
+        ref := &scriptReference {
+            capture : true,
+            group   : -1,
+        }
+
+        if n, err := strconv.Atoi(name); err == nil {
+            ref.group = n
+        }
+
+        return ref
+    }
+
+    ref := new(scriptReference)
+
+    if binding := corgi.bindReference(name); binding != nil {
+        ref.binding.Store(binding)
+    }
+
+    return ref
+}
+
+
+// bindReference resolves the variable name, nil is returned if it's not
+// registered.
+func (corgi *Corgi) bindReference(name string) *variableBinding {
+    root, path := splitPath(name)
+
+    generation := corgi.currentGeneration()
+
+    variable, varName, index := corgi.lookupVariable(root)
+    if variable == nil {
+        return nil
+    }
+
+    if path != nil {
+        index = -1
+    }
+
+    return &variableBinding {
+        variable   : variable,
+        name       : varName,
+        path       : path,
+        index      : index,
+        generation : generation,
+    }
+}
+
+
+// bound returns the binding of variable name, which is resolved again if
+// it's outdated, so that registering another variable costs a lookup only
+// once rather than every coding.
+func (ref *scriptReference) bound(corgi *Corgi, name string) *variableBinding {
+    binding, _ := ref.binding.Load().(*variableBinding)
+
+    if binding != nil && binding.generation == corgi.currentGeneration() {
+        return binding
+    }
+
+    if binding = corgi.bindReference(name); binding != nil {
+        ref.binding.Store(binding)
+    }
+
+    return binding
+}
+
+
+// valueOf gets the value of variable name, with the precompiled ref if any.
+func (c *coder) valueOf(ref *scriptReference,
+                        name string) (*VariableValue, error) {
+
+    if ref == nil {
+        return c.variableValue(name)
+    }
+
+    binding := ref.bound(c.corgi, name)
+    if binding == nil {
+        // reports the unknown variable
+        return c.variableValue(name)
+    }
+
+    return c.resolvedValue(binding.variable, binding.name, name, binding.path,
+                           binding.index, binding.generation)
+}
+
+
+// captureOf gets the value of capture group name, with the precompiled ref
+// if any.
+func (c *coder) captureOf(ref *scriptReference, name string) (string, bool) {
+    if ref == nil || ref.group < 0 {
+        return c.captureValue(name)
+    }
+
+    if ref.group >= len(c.group) {
+        return "", false
+    }
+
+    return c.group[ref.group], true
+}
+
+
+// isCapture reports whether the reference name is a capture group.
+func (ref *scriptReference) isCapture(name string) bool {
+    if ref == nil {
+        return isCaptureName(name)
+    }
+
+    return ref.capture
+}
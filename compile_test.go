@@ -0,0 +1,211 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "testing"
+)
+
+
+func variableGetName(value *VariableValue, _ interface{}, name string) error {
+    value.NotFound = false
+    value.Cacheable = false
+    value.Value = "[" + name + "]"
+
+    return nil
+}
+
+
+func variableGetUpperName(value *VariableValue, _ interface{},
+                          name string) error {
+
+    value.NotFound = false
+    value.Cacheable = false
+    value.Value = "<" + name + ">"
+
+    return nil
+}
+
+
+func expectCode(t *testing.T, c *Corgi, cv *ComplexValue, expected string) {
+    if data, err := c.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 data)
+    }
+}
+
+
+func testCompileReregister(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variables := []*Variable {
+        &Variable {
+            Name  : "user",
+            Get   : variableGetName,
+            Flags : VARIABLE_CHANGEABLE,
+        },
+
+        &Variable {
+            Name  : "arg_",
+            Get   : variableGetName,
+            Flags : VARIABLE_UNKNOWN|VARIABLE_CHANGEABLE,
+        },
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    cv, err := c.Parse("$user ${arg_id:-x} $?{user}{yes} ${user|upper}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "[user] [id] yes [USER]")
+
+    // the parsed template sees the replaced variables
+    replaced := []*Variable {
+        &Variable {
+            Name : "user",
+            Get  : variableGetUpperName,
+        },
+
+        &Variable {
+            Name  : "arg_",
+            Get   : variableGetUpperName,
+            Flags : VARIABLE_UNKNOWN,
+        },
+    }
+
+    if err := c.RegisterNewVariables(replaced); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "<user> <id> yes <USER>")
+
+    // the exact name takes precedence over the prefix "arg_"
+    exact := &Variable {
+        Name : "arg_id",
+        Get  : variableGetName,
+    }
+
+    if err := c.RegisterNewVariable(exact); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "<user> [arg_id] yes <USER>")
+}
+
+
+func testCompileLateBinding(t *testing.T) {
+    c, err := New(WithLateBinding())
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    cv, err := c.Parse("$user-${user:-none}-$1")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    c.Group = []string { "", "g1" }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "unknown variable \"user\"" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    variable := &Variable {
+        Name : "user",
+        Get  : variableGetName,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "[user]-[user]-g1")
+
+    c.Group = []string { "" }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "too large capture number" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+// testCompileRebind registers an unrelated variable, the references are
+// resolved again only once, rather than looked up by names every coding.
+func testCompileRebind(t *testing.T) {
+    c, err := New(WithLateBinding())
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variable := &Variable {
+        Name : "user",
+        Get  : variableGetName,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse("$user $late")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    user := cv.code[0].ref
+    late := cv.code[2].ref
+
+    first, _ := user.binding.Load().(*variableBinding)
+    if first == nil {
+        t.Fatal("reference is not resolved by parsing")
+    }
+
+    variable = &Variable {
+        Name : "late",
+        Get  : variableGetName,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "[user] [late]")
+
+    second, _ := user.binding.Load().(*variableBinding)
+    if second == first || second.generation != c.currentGeneration() {
+        t.Fatal("reference is not resolved again")
+    }
+
+    if binding, _ := late.binding.Load().(*variableBinding); binding == nil {
+        t.Fatal("late bound reference is not resolved")
+    }
+
+    expectCode(t, c, cv, "[user] [late]")
+
+    // the binding is used by the later codings
+    if binding, _ := user.binding.Load().(*variableBinding); binding != second {
+        t.Fatal("reference is resolved again without registering")
+    }
+}
+
+
+func TestCompile(t *testing.T) {
+    testCompileReregister(t)
+    testCompileLateBinding(t)
+    testCompileRebind(t)
+}
@@ -0,0 +1,343 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "sync"
+    "bytes"
+    "context"
+    "sync/atomic"
+    "time"
+    "strings"
+    "testing"
+)
+
+
+const (
+    CONCURRENT_WORKERS = 8
+    CONCURRENT_LOOPS   = 500
+
+    // the resolutions of a variable in progress at once
+    CONCURRENT_RESOLUTIONS = 1100
+)
+
+
+func variableGetVersion(version string) VariableGetHandler {
+    return func(value *VariableValue, ctx interface{}, name string) error {
+        value.NotFound = false
+        value.Cacheable = true
+        value.Value = version
+
+        if request, ok := ctx.(*requestContext); ok {
+            // the uncacheable value depends on the context
+            value.Cacheable = false
+            value.Value = request.user
+        }
+
+        return nil
+    }
+}
+
+
+// barrier is passed by all the resolutions in progress at once, or by the
+// ones which arrive in a second.
+type barrier struct {
+    arrived  int32
+    all      chan struct {}
+}
+
+
+// variableGetBarrier waits until all the resolutions are in progress.
+func variableGetBarrier(value *VariableValue, ctx interface{}, _ string) error {
+    b := ctx.(*barrier)
+
+    if atomic.AddInt32(&b.arrived, 1) == CONCURRENT_RESOLUTIONS {
+        close(b.all)
+    }
+
+    select {
+    case <-b.all:
+    case <-time.After(time.Second):
+    }
+
+    value.NotFound = false
+    value.Value = "slow"
+
+    return nil
+}
+
+
+func newConcurrentCorgi(t *testing.T) *Corgi {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variables := []*Variable {
+        &Variable {
+            Name  : "version",
+            Get   : variableGetVersion("v0"),
+            Flags : VARIABLE_CHANGEABLE,
+        },
+
+        &Variable {
+            Name  : "arg_",
+            Get   : variableGetVersion("a0"),
+            Flags : VARIABLE_UNKNOWN|VARIABLE_CHANGEABLE,
+        },
+
+        &Variable {
+            Name : "user",
+            Get  : variableGetUser,
+        },
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    if err := c.RegisterFilter("up", predefineFilterUpper); err != nil {
+        t.Fatalf("failed to register filter: %s", err.Error())
+    }
+
+    return c
+}
+
+
+// testConcurrentCode codes the templates concurrently, while the variables
+// are registered again.
+func testConcurrentCode(t *testing.T) {
+    var wg      sync.WaitGroup
+
+    c := newConcurrentCorgi(t)
+
+    text := "$version ${arg_id:-none} ${user:-nobody} $pid"
+
+    cv, err := c.Parse(text)
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    optimized := c.Optimize(cv)
+
+    errors := make(chan error, CONCURRENT_WORKERS * 2 + 1)
+
+    for i := 0; i < CONCURRENT_WORKERS; i++ {
+        wg.Add(1)
+
+        go func(worker int) {
+            defer wg.Done()
+
+            var buffer    bytes.Buffer
+            var dst       []byte
+
+            ctx := &requestContext { user : fmt.Sprintf("user%d", worker) }
+
+            for j := 0; j < CONCURRENT_LOOPS; j++ {
+                data, err := c.Code(cv)
+                if err != nil {
+                    errors <- err
+                    return
+                }
+
+                fields := strings.Fields(data)
+                if len(fields) != 4 || fields[2] != "nobody" ||
+                   strings.HasPrefix(fields[0], "v") == false ||
+                   strings.HasPrefix(fields[1], "a") == false {
+
+                    errors <- fmt.Errorf("unexpected value \"%s\"", data)
+                    return
+                }
+
+                data, err = c.CodeWith(optimized, ctx, nil)
+                if err != nil {
+                    errors <- err
+                    return
+                }
+
+                // the folded values are seen until they are invalidated
+                fields = strings.Fields(data)
+                if len(fields) != 4 || fields[2] != ctx.user {
+                    errors <- fmt.Errorf("unexpected value \"%s\"", data)
+                    return
+                }
+
+                buffer.Reset()
+
+                if _, err := c.CodeTo(&buffer, optimized); err != nil {
+                    errors <- err
+                    return
+                }
+
+                if dst, err = c.AppendCode(dst[:0], cv); err != nil {
+                    errors <- err
+                    return
+                }
+            }
+        }(i)
+    }
+
+    for i := 0; i < CONCURRENT_WORKERS; i++ {
+        wg.Add(1)
+
+        // parses the templates while the variables are registered
+        go func() {
+            defer wg.Done()
+
+            for j := 0; j < CONCURRENT_LOOPS; j++ {
+                cv, err := c.Parse("${version|up} ${arg_x} $(( 1 + 2 ))")
+                if err != nil {
+                    errors <- err
+                    return
+                }
+
+                if _, err := c.Code(c.Optimize(cv)); err != nil {
+                    errors <- err
+                    return
+                }
+            }
+        }()
+    }
+
+    wg.Add(1)
+
+    go func() {
+        defer wg.Done()
+
+        for j := 0; j < CONCURRENT_LOOPS; j++ {
+            variables := []*Variable {
+                &Variable {
+                    Name  : "version",
+                    Get   : variableGetVersion(fmt.Sprintf("v%d", j)),
+                    Flags : VARIABLE_CHANGEABLE,
+                },
+
+                &Variable {
+                    Name  : "arg_",
+                    Get   : variableGetVersion(fmt.Sprintf("a%d", j)),
+                    Flags : VARIABLE_UNKNOWN|VARIABLE_CHANGEABLE,
+                },
+            }
+
+            if err := c.RegisterNewVariables(variables); err != nil {
+                errors <- err
+                return
+            }
+
+            if err := c.RegisterFilter("up", predefineFilterUpper); err != nil {
+                errors <- err
+                return
+            }
+        }
+    }()
+
+    wg.Wait()
+    close(errors)
+
+    for err := range errors {
+        t.Fatal(err.Error())
+    }
+
+    // the last registered variables are seen
+    expectCode(t, c, cv, fmt.Sprintf("v%d a%d nobody %s", CONCURRENT_LOOPS - 1,
+                                     CONCURRENT_LOOPS - 1,
+                                     parse(t, c, "$pid")))
+}
+
+
+// testConcurrentResolving resolves a variable in many concurrent codings at
+// once, which are not recursive.
+func testConcurrentResolving(t *testing.T) {
+    var wg      sync.WaitGroup
+
+    c := newConcurrentCorgi(t)
+
+    variable := &Variable {
+        Name  : "slow",
+        Get   : variableGetBarrier,
+        Flags : VARIABLE_NO_CACHEABLE,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse("${slow}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    b := &barrier {
+        all : make(chan struct {}),
+    }
+
+    c.Context = b
+
+    errors := make(chan error, CONCURRENT_RESOLUTIONS)
+
+    for i := 0; i < CONCURRENT_RESOLUTIONS; i++ {
+        wg.Add(1)
+
+        go func(worker int) {
+            defer wg.Done()
+
+            var data    string
+            var err     error
+
+            switch worker % 3 {
+
+            case 0:
+                data, err = c.CodeWith(cv, b, nil)
+
+            case 1:
+                data, err = c.CodeContext(context.Background(), cv)
+
+            default:
+                session := c.NewSession(b)
+                data, err = session.Code(cv)
+                session.Release()
+            }
+
+            if err != nil {
+                errors <- err
+
+            } else if data != "slow" {
+                errors <- fmt.Errorf("unexpected value \"%s\"", data)
+            }
+        }(i)
+    }
+
+    wg.Wait()
+    close(errors)
+
+    for err := range errors {
+        t.Fatal(err.Error())
+    }
+}
+
+
+func TestConcurrent(t *testing.T) {
+    testConcurrentCode(t)
+    testConcurrentResolving(t)
+}
+
+
+func BenchmarkAppendCodeParallel(b *testing.B) {
+    c, cv := newAppendCodeCorgi(b)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    b.RunParallel(func(pb *testing.PB) {
+        var err error
+
+        dst := make([]byte, 0, 256)
+
+        for pb.Next() {
+            if dst, err = c.AppendCode(dst[:0], cv); err != nil {
+                b.Fatal(err.Error())
+            }
+        }
+    })
+}
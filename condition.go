@@ -0,0 +1,170 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "io"
+    "fmt"
+    "errors"
+)
+
+
+const (
+    CONDITION_MARK   = '?'
+    CONDITION_NEGATE = '!'
+)
+
+
+// scriptCondition describes the conditional section, e.g.
+// $?{name}{then}{otherwise}, the section then will be emitted if the
+// variable is found and not empty(or the opposite if negative is true),
+// otherwise is nil if the else section is absent.
+type scriptCondition struct {
+    negative    bool
+    then       *ComplexValue
+    otherwise  *ComplexValue
+}
+
+
+// parseSection parses the section of a condition, which is wrapped by the
+// brackets.
+func (p *parser) parseSection() (*ComplexValue, error) {
+    section := p.corgi.newComplexValue()
+
+    lbracket, rbracket := p.syntax.section()
+
+    from := p.pos
+
+    // skips the left bracket
+    p.pos += len(lbracket)
+
+    err := p.parseSequence(section, []string { rbracket })
+    if err != nil {
+        return nil, err
+    }
+
+    if p.pos == len(p.text) {
+        err := fmt.Errorf("unexpected end of string, \"%s\" is missing",
+                          rbracket)
+
+        return nil, p.fail(newParseError(ErrUnterminated, err), from,
+                           from + len(lbracket))
+    }
+
+    // skips the right bracket
+    p.pos += len(rbracket)
+
+    return section, nil
+}
+
+
+// parseCondition parses the conditional section, opened reports whether the
+// left bracket is consumed with the preface.
+func (p *parser) parseCondition(cv *ComplexValue, opened bool) error {
+    var condition *scriptCondition = new(scriptCondition)
+    var err        error
+
+    from := p.pos - len(p.syntax.opener())
+
+    // skips the condition mark
+    p.pos++
+
+    if opened == false {
+        if p.hasPrefix(p.syntax.LBracket) == false {
+            return p.fail(newParseError(ErrInvalidName,
+                                        errors.New("invalid variable name")),
+                          from, p.pos)
+        }
+
+        p.pos += len(p.syntax.LBracket)
+    }
+
+    open := p.open
+    p.open = span { from, p.pos }
+
+    if p.pos < len(p.text) && p.text[p.pos] == CONDITION_NEGATE {
+        condition.negative = true
+        p.pos++
+    }
+
+    start := p.pos
+
+    var name string
+
+    if p.atCaptureName() {
+        if name, err = p.parseCaptureName(); err != nil {
+            return err
+        }
+
+    } else {
+        name = p.parsePath()
+    }
+
+    if name == "" {
+        return p.fail(newParseError(ErrInvalidName,
+                                    errors.New("invalid variable name")),
+                      from, p.pos)
+    }
+
+    if err := p.closeBracket(name); err != nil {
+        return err
+    }
+
+    p.open = open
+
+    lbracket, _ := p.syntax.section()
+
+    if p.hasPrefix(lbracket) == false {
+        return fmt.Errorf("section for condition \"%s\" is missing", name)
+    }
+
+    if condition.then, err = p.parseSection(); err != nil {
+        return err
+    }
+
+    if p.hasPrefix(lbracket) {
+        if condition.otherwise, err = p.parseSection(); err != nil {
+            return err
+        }
+    }
+
+    kind, err := cv.reference(name)
+    if err != nil {
+        return p.unknownVariable(cv, err, from, start, name)
+    }
+
+    if kind == SCRIPT_CAPTURE {
+        name = captureName(name)
+    }
+
+    cv.code = append(cv.code, scriptCode {
+        kind      : SCRIPT_CONDITION,
+        data      : name,
+        ref       : cv.corgi.compileReference(kind, name),
+        condition : condition,
+    })
+
+    cv.size++
+
+    return nil
+}
+
+
+func (c *coder) conditionCode(w io.Writer, code *scriptCode) error {
+    condition := code.condition
+
+    value, found, err := c.referenceValue(code.ref, code.data)
+    if err != nil {
+        return err
+    }
+
+    if (found && value != "") != condition.negative {
+        return c.code(w, condition.then)
+    }
+
+    if condition.otherwise != nil {
+        return c.code(w, condition.otherwise)
+    }
+
+    return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "testing"
+)
+
+
+func testConditionSection(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    c.Group = []string { "", "first" }
+
+    cases := map[string]string {
+        "a$?{set}{, set is $set}"                : "a, set is value",
+        "a$?{null}{, null is $null}"             : "a",
+        "a$?{unset}{, unset is $unset}"          : "a",
+        "a$?{unset}{ set}{ unset}"               : "a unset",
+        "a$?{set}{ set}{ unset}"                 : "a set",
+        "a$?{!unset}{ unset}"                    : "a unset",
+        "a$?{!set}{ unset}{ set}"                : "a set",
+        "$?{set}{$?{null}{x}{${set|upper}}}"     : "VALUE",
+        "$?{1}{$1}{none}$?{2}{$2}{none}"         : "firstnone",
+        "$?{env_corgi_xxxxx}{env}{no env}"       : "no env",
+        "{$?{set}{}}"                            : "{}",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+}
+
+
+func testConditionParseFailed(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "$?"                : "invalid variable name",
+        "$?set"             : "invalid variable name",
+        "$?{}{x}"           : "invalid variable name",
+        "$?{set"            : "unexpected end of string, \"}\" is missing",
+        "$?{set x}{x}"      : "\"}\" for variable \"set\" is missing",
+        "$?{set}"           : "section for condition \"set\" is missing",
+        "$?{set} {x}"       : "section for condition \"set\" is missing",
+        "$?{set}{x"         : "unexpected end of string, \"}\" is missing",
+        "$?{set}{x}{y"      : "unexpected end of string, \"}\" is missing",
+        "$?{xxxxx}{x}"      : "unknown variable \"xxxxx\"",
+        "$?{set}{$xxxxx}"   : "unknown variable \"xxxxx\"",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func TestCondition(t *testing.T) {
+    testConditionSection(t)
+    testConditionParseFailed(t)
+}
@@ -3,6 +3,12 @@
 // Package corgi does the variables interpolation job.
 package corgi
 
+import (
+    "sync"
+    "regexp"
+    "sync/atomic"
+)
+
 
 const (
     VARIABLE_SLOTS = 16
@@ -12,28 +18,70 @@ const (
 // Corgi is the core struct for user.
 // The field Context, holds any type data that the caller wants to save,
 // which will be used inside the variable get/set handler.
+// The field Group, holds the regular expression capture groups, which can be
+// filled by the method BindRegexp.
+// Corgi is safe for concurrent use, except the fields Context and Group(and
+// the method BindRegexp which sets Group), use CodeWith to code with the
+// different contexts concurrently.
 type Corgi struct {
-    variables map[string]*Variable
-    unknowns  map[string]*Variable
-    caches    map[string]*VariableValue
-    Context   interface{}
-    Group   []string
+    // accessed atomically, the first word is 64-bit aligned
+    generation   uint64
+
+    // protects the variables, the filters, the functions and the names of
+    // capture groups
+    lock         sync.RWMutex
+    variables    map[string]*Variable
+    unknowns     map[string]*Variable
+    indexes      map[string]int
+    caches       variableCache
+    slots        atomic.Value
+    filters      map[string]FilterHandler
+    functions    map[string]*Function
+    syntax       Syntax
+    lenient      bool
+    passes      *regexp.Regexp
+    lateBinding  bool
+    groupNames   map[string]int
+    captureNames map[string]bool
+    Context      interface{}
+    Group      []string
 }
 
 
-// New returns an instance of Corgi.
+// New returns an instance of Corgi, which is configured by options, e.g.
+// WithSyntax.
 // In case of failure, nil and the corresponding error object will be yielded.
 // In case of success, the error object will be nil.
-func New() (*Corgi, error) {
+func New(options ...Option) (*Corgi, error) {
     var corgi *Corgi = new(Corgi)
 
+    corgi.syntax = DefaultSyntax
+
+    for _, option := range options {
+        if err := option(corgi); err != nil {
+            return nil, err
+        }
+    }
+
     corgi.variables = make(map[string]*Variable, VARIABLE_SLOTS)
     corgi.unknowns = make(map[string]*Variable, VARIABLE_SLOTS >> 1)
-    corgi.caches = make(map[string]*VariableValue, VARIABLE_SLOTS)
+    corgi.indexes = make(map[string]int, VARIABLE_SLOTS)
+    corgi.caches.init()
+    corgi.filters = make(map[string]FilterHandler, len(predefineFilters))
+    corgi.functions = make(map[string]*Function, len(predefineFunctions))
+    corgi.captureNames = make(map[string]bool)
 
     if err := corgi.registerPredefineVariables(); err != nil {
         return nil, err
     }
 
+    if err := corgi.registerPredefineFilters(); err != nil {
+        return nil, err
+    }
+
+    if err := corgi.registerPredefineFunctions(); err != nil {
+        return nil, err
+    }
+
     return corgi, nil
 }
@@ -0,0 +1,351 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "unicode/utf8"
+)
+
+
+// The kinds of ParseError, which can be compared with errors.Is.
+var (
+    // ErrSyntax, the generic syntax error, e.g. an unknown operator or filter
+    ErrSyntax = errors.New("syntax error")
+
+    // ErrInvalidName, the variable name is missing or malformed
+    ErrInvalidName = errors.New("invalid variable name")
+
+    // ErrUnknownVariable, the variable is neither registered nor unknown
+    ErrUnknownVariable = errors.New("unknown variable")
+
+    // ErrUnterminated, a bracket, parenthesis or quote is not closed
+    ErrUnterminated = errors.New("unterminated bracket")
+
+    // ErrBadCapture, the capture group number is out of range or misused
+    ErrBadCapture = errors.New("bad capture group number")
+)
+
+
+// ParseError describles the failure of Corgi.Parse.
+// Kind, one of ErrSyntax, ErrInvalidName, ErrUnknownVariable,
+// ErrUnterminated and ErrBadCapture.
+// Err, the underlying error, whose message is also the one of ParseError.
+// Offset, the byte offset of Token in the template.
+// Line, the line number of Token, starts from 1.
+// Column, the column number(in characters) of Token, starts from 1.
+// Token, the offending part of the template, can be empty at the end.
+// Snippet, the line containing Token, with a caret line pointing at it.
+type ParseError struct {
+    Kind     error
+    Err      error
+    Offset   int
+    Line     int
+    Column   int
+    Token    string
+    Snippet  string
+}
+
+
+func (e *ParseError) Error() string {
+    return e.Err.Error()
+}
+
+
+// Is reports whether target is the kind of e, so that errors.Is(err,
+// ErrUnknownVariable) works.
+func (e *ParseError) Is(target error) bool {
+    return e.Kind == target
+}
+
+
+func (e *ParseError) Unwrap() error {
+    return e.Err
+}
+
+
+func newParseError(kind error, err error) *ParseError {
+    return &ParseError {
+        Kind   : kind,
+        Err    : err,
+        Offset : -1,
+    }
+}
+
+
+// fail attaches the position [from, to) to err, the one which already has a
+// position is returned as is, and the plain errors are treated as ErrSyntax.
+func (p *parser) fail(err error, from, to int) error {
+    e, ok := err.(*ParseError)
+    if ok == false {
+        e = newParseError(ErrSyntax, err)
+    }
+
+    if e.Offset >= 0 {
+        return e
+    }
+
+    if to <= from && from < len(p.text) {
+        _, size := utf8.DecodeRuneInString(p.text[from:])
+        to = from + size
+    }
+
+    e.Offset = from
+    e.Token = p.text[from:to]
+
+    return e
+}
+
+
+// locate fills the line, column and snippet of e.
+func (p *parser) locate(e *ParseError) {
+    start := strings.LastIndexByte(p.text[:e.Offset], '\n') + 1
+
+    end := strings.IndexByte(p.text[e.Offset:], '\n')
+    if end < 0 {
+        end = len(p.text)
+
+    } else {
+        end += e.Offset
+    }
+
+    e.Line = strings.Count(p.text[:start], "\n") + 1
+    e.Column = utf8.RuneCountInString(p.text[start:e.Offset]) + 1
+
+    // the caret line keeps tabs, so that it is aligned with the line
+    var caret []byte
+
+    for _, ch := range p.text[start:e.Offset] {
+        if ch == '\t' {
+            caret = append(caret, '\t')
+
+        } else {
+            caret = append(caret, ' ')
+        }
+    }
+
+    token := e.Token
+    if i := strings.IndexByte(token, '\n'); i >= 0 {
+        token = token[:i]
+    }
+
+    marks := utf8.RuneCountInString(token)
+    if marks == 0 {
+        marks = 1
+    }
+
+    caret = append(caret, strings.Repeat("^", marks)...)
+
+    e.Snippet = p.text[start:end] + "\n" + string(caret)
+}
+
+
+// ParseErrors is the list of errors yielded by Corgi.ParseAll, in order of
+// their offsets.
+type ParseErrors []*ParseError
+
+
+func (list ParseErrors) Error() string {
+    switch len(list) {
+    case 0:
+        return "no errors"
+
+    case 1:
+        return list[0].Error()
+    }
+
+    return fmt.Sprintf("%s (and %d more errors)", list[0].Error(),
+                       len(list) - 1)
+}
+
+
+// Is reports whether any error in list is the kind target.
+func (list ParseErrors) Is(target error) bool {
+    for _, e := range list {
+        if e.Is(target) {
+            return true
+        }
+    }
+
+    return false
+}
+
+
+// Unwrap returns the errors in list, which are walked by errors.Is and
+// errors.As since Go 1.20.
+func (list ParseErrors) Unwrap() []error {
+    errs := make([]error, len(list))
+
+    for i, e := range list {
+        errs[i] = e
+    }
+
+    return errs
+}
+
+
+// As finds the first error in list which matches target, so that
+// errors.As(err, &e) gets the first *ParseError before Go 1.20 as well.
+func (list ParseErrors) As(target interface{}) bool {
+    for _, e := range list {
+        if errors.As(e, target) {
+            return true
+        }
+    }
+
+    return false
+}
+
+
+// recover records err, which fails the variable reference starting at
+// from, and skips the reference, which is kept as the plain text, the codes
+// appended after the first size ones are dropped.
+func (p *parser) recover(cv *ComplexValue, err error, from, size int) error {
+    e := p.fail(err, p.pos, p.pos).(*ParseError)
+    p.locate(e)
+
+    p.errors = append(p.errors, e)
+
+    cv.code = cv.code[:size]
+    cv.size = size
+
+    p.pos = from + len(p.syntax.opener())
+
+    if p.pos < len(p.text) && p.text[p.pos] == CONDITION_MARK {
+        p.pos++
+
+        if p.syntax.bare() && p.hasPrefix(p.syntax.LBracket) == false {
+            return cv.append(p.text[from:p.pos], false)
+        }
+
+        p.skipBracket()
+
+        // the then and otherwise sections
+        for i := 0; i < 2; i++ {
+            p.skipSection()
+        }
+
+    } else if p.hasPrefix(ARITHMETIC_OPEN) {
+        p.skipArgs()
+
+        if p.syntax.bare() == false && p.atRBracket() {
+            p.pos += len(p.syntax.RBracket)
+        }
+
+    } else if p.syntax.bare() && p.hasPrefix(p.syntax.LBracket) == false {
+        name := p.parseName()
+
+        if p.corgi.lookupFunction(name) != nil {
+            p.skipArgs()
+        }
+
+    } else {
+        p.skipBracket()
+    }
+
+    return cv.append(p.text[from:p.pos], false)
+}
+
+
+// skipBracket skips to the right bracket which closes the current one, or
+// the end of text.
+func (p *parser) skipBracket() {
+    var depth int = 1
+
+    if p.syntax.bare() {
+        p.pos += len(p.syntax.LBracket)
+    }
+
+    for p.pos < len(p.text) {
+        if p.atRBracket() {
+            p.pos += len(p.syntax.RBracket)
+
+            if depth--; depth == 0 {
+                return
+            }
+
+            continue
+        }
+
+        if p.atOpener() {
+            p.pos += len(p.syntax.opener())
+
+            if p.syntax.Preface == "" {
+                // the opener is the left bracket itself
+                depth++
+
+            } else if p.syntax.bare() == false ||
+                      p.hasPrefix(p.syntax.LBracket) {
+
+                p.pos += len(p.syntax.LBracket)
+                depth++
+            }
+
+            continue
+        }
+
+        p.pos++
+    }
+
+    if p.pos > len(p.text) {
+        p.pos = len(p.text)
+    }
+}
+
+
+// skipSection skips the section of a condition if any.
+func (p *parser) skipSection() {
+    var depth int
+
+    lbracket, rbracket := p.syntax.section()
+
+    if p.hasPrefix(lbracket) == false {
+        return
+    }
+
+    for p.pos < len(p.text) {
+        if p.hasPrefix(rbracket) {
+            p.pos += len(rbracket)
+
+            if depth--; depth == 0 {
+                return
+            }
+
+            continue
+        }
+
+        if p.hasPrefix(lbracket) {
+            p.pos += len(lbracket)
+            depth++
+
+            continue
+        }
+
+        p.pos++
+    }
+}
+
+
+// skipArgs skips the arguments of a function call if any.
+func (p *parser) skipArgs() {
+    var depth int
+
+    if p.pos == len(p.text) || p.text[p.pos] != FILTER_LPAREN {
+        return
+    }
+
+    for ; p.pos < len(p.text); p.pos++ {
+        switch p.text[p.pos] {
+        case FILTER_LPAREN:
+            depth++
+
+        case FILTER_RPAREN:
+            if depth--; depth == 0 {
+                p.pos++
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,204 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "errors"
+    "testing"
+)
+
+
+type parseErrorCase struct {
+    text     string
+    kind     error
+    offset   int
+    line     int
+    column   int
+    token    string
+    snippet  string
+}
+
+
+func testParseErrorPosition(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := []parseErrorCase {
+        { "hello $", ErrInvalidName, 6, 1, 7, "$", "hello $\n      ^" },
+        { "hello ${hostname", ErrUnterminated, 6, 1, 7, "${",
+          "hello ${hostname\n      ^^" },
+        { "a\n\tb ${set:-${set", ErrUnterminated, 12, 2, 11, "${",
+          "\tb ${set:-${set\n\t         ^^" },
+        { "line\nyo $foo bar", ErrUnknownVariable, 9, 2, 5, "foo",
+          "yo $foo bar\n    ^^^" },
+        { "世界 ${100}", ErrBadCapture, 9, 1, 6, "100", "世界 ${100}\n     ^^^" },
+        { "${1:=x}", ErrBadCapture, 2, 1, 3, "1", "${1:=x}\n  ^" },
+        { "${set x}", ErrUnterminated, 5, 1, 6, " ", "${set x}\n     ^" },
+        { "$?{set}{a", ErrUnterminated, 7, 1, 8, "{", "$?{set}{a\n       ^" },
+        { "$?{xxxxx}{a}", ErrUnknownVariable, 3, 1, 4, "xxxxx",
+          "$?{xxxxx}{a}\n   ^^^^^" },
+        { "$upper(\"x)", ErrUnterminated, 7, 1, 8, "\"", "$upper(\"x)\n       ^" },
+        { "${set|nope}", ErrSyntax, 6, 1, 7, "nope", "${set|nope}\n      ^^^^" },
+        { "${}\nx", ErrInvalidName, 0, 1, 1, "${}", "${}\n^^^" },
+    }
+
+    for _, tc := range cases {
+        _, err := c.Parse(tc.text)
+        if err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", tc.text)
+        }
+
+        e, ok := err.(*ParseError)
+        if ok == false {
+            t.Fatalf("unexpected error type of \"%s\": %T", tc.text, err)
+        }
+
+        if errors.Is(err, tc.kind) == false {
+            t.Fatalf("incorrect kind of \"%s\": %v", tc.text, e.Kind)
+        }
+
+        if e.Offset != tc.offset || e.Line != tc.line || e.Column != tc.column {
+            t.Fatalf("incorrect position of \"%s\": %d %d:%d", tc.text,
+                     e.Offset, e.Line, e.Column)
+        }
+
+        if e.Token != tc.token {
+            t.Fatalf("incorrect token of \"%s\": \"%s\"", tc.text, e.Token)
+        }
+
+        if e.Snippet != tc.snippet {
+            t.Fatalf("incorrect snippet of \"%s\":\n%s", tc.text, e.Snippet)
+        }
+    }
+}
+
+
+func testParseErrorKind(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    _, err := c.Parse("$xxxxx")
+    if err == nil {
+        t.Fatal("unexpected successful parsing")
+    }
+
+    // the message is kept
+    if err.Error() != "unknown variable \"xxxxx\"" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    if errors.Is(err, ErrUnknownVariable) == false {
+        t.Fatal("unknown variable is not ErrUnknownVariable")
+    }
+
+    for _, kind := range []error { ErrSyntax, ErrInvalidName, ErrUnterminated,
+                                   ErrBadCapture } {
+
+        if errors.Is(err, kind) {
+            t.Fatalf("unknown variable is %v", kind)
+        }
+    }
+
+    var e *ParseError
+    if errors.As(err, &e) == false || e.Token != "xxxxx" {
+        t.Fatal("failed to get the ParseError")
+    }
+}
+
+
+func testParseAll(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    text := "a $foo b ${bar:-$set} c ${set x} d $? e ${set} $upper($set x)\n" +
+            "f $?{xxxxx}{x}{y} g $set ${100:-x} h ${set"
+
+    cv, err := c.ParseAll(text)
+    if err == nil {
+        t.Fatal("unexpected successful parsing")
+    }
+
+    list, ok := err.(ParseErrors)
+    if ok == false {
+        t.Fatalf("unexpected error type: %T", err)
+    }
+
+    expected := []parseErrorCase {
+        { kind : ErrUnknownVariable, offset : 3, token : "foo" },
+        { kind : ErrUnknownVariable, offset : 11, token : "bar" },
+        { kind : ErrUnterminated, offset : 29, token : " " },
+        { kind : ErrInvalidName, offset : 35, token : "$?" },
+        { kind : ErrSyntax, offset : 59, token : "x" },
+        { kind : ErrUnknownVariable, offset : 67, token : "xxxxx" },
+        { kind : ErrBadCapture, offset : 89, token : "100" },
+        { kind : ErrUnterminated, offset : 99, token : "${" },
+    }
+
+    if len(list) != len(expected) {
+        t.Fatalf("incorrect number of errors: %d", len(list))
+    }
+
+    for i, e := range list {
+        if errors.Is(e, expected[i].kind) == false ||
+           e.Offset != expected[i].offset || e.Token != expected[i].token {
+
+            t.Fatalf("incorrect error #%d: %s, %d, \"%s\"", i, e.Error(),
+                     e.Offset, e.Token)
+        }
+    }
+
+    if errors.Is(err, ErrBadCapture) == false {
+        t.Fatal("errors is not ErrBadCapture")
+    }
+
+    // the errors in list are unwrapped, even if the list is wrapped
+    var e *ParseError
+
+    wrapped := fmt.Errorf("template \"t\": %w", err)
+    if errors.As(wrapped, &e) == false || e != list[0] {
+        t.Fatal("failed to get the first ParseError")
+    }
+
+    if errors.Is(wrapped, ErrUnterminated) == false ||
+       len(list.Unwrap()) != len(expected) {
+
+        t.Fatal("failed to unwrap the ParseErrors")
+    }
+
+    if err.Error() != "unknown variable \"foo\" (and 7 more errors)" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the bad references are kept as the plain text
+    expectedText := "a $foo b ${bar:-$set} c ${set x} d $? e value " +
+                    "$upper($set x)\nf $?{xxxxx}{x}{y} g value ${100:-x} h ${set"
+
+    if data, err := c.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != expectedText {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"",
+                 expectedText, data)
+    }
+
+    if _, err := c.ParseAll("$set ${set:-$set}"); err != nil {
+        t.Fatalf("unexpected failure: %s", err.Error())
+    }
+
+    c, err = New(WithSyntax(MustacheSyntax))
+    if err != nil {
+        t.Fatalf("failed to create corgi instance: %s", err.Error())
+    }
+
+    _, err = c.ParseAll("{{xx}} {{?yy}}{{a {{zz}}}}{{b}} {{hostname}} {{")
+    if list, ok := err.(ParseErrors); ok == false || len(list) != 3 ||
+       list[1].Token != "zz" || list[2].Offset != 45 {
+
+        t.Fatalf("unexpected errors: %v", err)
+    }
+}
+
+
+func TestParseError(t *testing.T) {
+    testParseErrorPosition(t)
+    testParseErrorKind(t)
+    testParseAll(t)
+}
@@ -0,0 +1,250 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "io"
+    "fmt"
+    "html"
+    "strings"
+    "net/url"
+    "unicode/utf8"
+)
+
+
+const (
+    ESCAPE_FILTER = "escape"
+)
+
+
+// Escaper escapes a value for the output context, like a JSON string or an
+// HTML page, it's applied to the values of references(after the filters and
+// the width control), but never to the literal text of template.
+type Escaper func(value string) string
+
+
+var predefineEscapers map[string]Escaper = map[string]Escaper {
+    "json"  : EscapeJSON,
+    "html"  : EscapeHTML,
+    "shell" : EscapeShell,
+    "url"   : EscapeURL,
+    "csv"   : EscapeCSV,
+    "none"  : escapeNone,
+}
+
+
+const hexDigits = "0123456789abcdef"
+
+
+// EscapeJSON escapes value as the content of a JSON string, the quotes are
+// not added, since they are usually in the template, like "$name".
+func EscapeJSON(value string) string {
+    var builder    strings.Builder
+
+    last := 0
+
+    for i := 0; i < len(value); {
+        ch := value[i]
+
+        if ch >= utf8.RuneSelf {
+            r, size := utf8.DecodeRuneInString(value[i:])
+
+            // the line and paragraph separators break the JavaScript
+            if r != utf8.RuneError && r != '\u2028' && r != '\u2029' {
+                i += size
+                continue
+            }
+
+            builder.WriteString(value[last:i])
+
+            if r == utf8.RuneError {
+                builder.WriteString("\\ufffd")
+
+            } else {
+                builder.WriteString("\\u202")
+                builder.WriteByte(hexDigits[r & 0xF])
+            }
+
+            i += size
+            last = i
+
+            continue
+        }
+
+        if ch >= 0x20 && ch != '"' && ch != '\\' {
+            i++
+            continue
+        }
+
+        builder.WriteString(value[last:i])
+
+        switch ch {
+
+        case '"', '\\':
+            builder.WriteByte('\\')
+            builder.WriteByte(ch)
+
+        case '\n':
+            builder.WriteString("\\n")
+
+        case '\r':
+            builder.WriteString("\\r")
+
+        case '\t':
+            builder.WriteString("\\t")
+
+        default:
+            builder.WriteString("\\u00")
+            builder.WriteByte(hexDigits[ch >> 4])
+            builder.WriteByte(hexDigits[ch & 0xF])
+        }
+
+        i++
+        last = i
+    }
+
+    if last == 0 {
+        // nothing is escaped
+        return value
+    }
+
+    builder.WriteString(value[last:])
+
+    return builder.String()
+}
+
+
+// EscapeHTML escapes the special characters of HTML, i.e. <, >, &, ' and ".
+func EscapeHTML(value string) string {
+    return html.EscapeString(value)
+}
+
+
+// EscapeShell quotes value as a single argument of POSIX shell, i.e. wraps
+// it with the single quotes, the single quote inside is written as '\''.
+func EscapeShell(value string) string {
+    return "'" + strings.Replace(value, "'", "'\\''", -1) + "'"
+}
+
+
+// EscapeURL escapes value as a component of the URL query.
+func EscapeURL(value string) string {
+    return url.QueryEscape(value)
+}
+
+
+// EscapeCSV quotes value as a CSV field(RFC 4180) if it contains the comma,
+// the quote or the line break, or it starts with the space, the quote
+// inside is doubled.
+func EscapeCSV(value string) string {
+    if value == "" || (strings.ContainsAny(value, ",\"\r\n") == false &&
+                       value[0] != ' ' && value[0] != '\t') {
+
+        return value
+    }
+
+    return "\"" + strings.Replace(value, "\"", "\"\"", -1) + "\""
+}
+
+
+// escapeNone keeps the value as is, which disables the escaper of template
+// for a reference, like ${name|escape(none)}.
+func escapeNone(value string) string {
+    return value
+}
+
+
+// escapeTo writes the escaped value of code to w, the width control is
+// applied before escaping, so that the padding is inside the quotes of the
+// CSV field and the shell argument.
+func (code *scriptCode) escapeTo(w io.Writer, value string) error {
+    if code.format != nil {
+        var err error
+
+        if value, err = code.format.string(value); err != nil {
+            return err
+        }
+    }
+
+    return writeString(w, code.escape(value))
+}
+
+
+// parseEscaper parses the escaper of a reference, like "|escape(json)", the
+// name has been parsed.
+func (p *parser) parseEscaper(from int) (Escaper, error) {
+    if p.pos == len(p.text) || p.text[p.pos] != FILTER_LPAREN {
+        return nil, p.fail(fmt.Errorf("filter \"%s\": expects 1 argument(s) "+
+                                      "but 0 given", ESCAPE_FILTER),
+                           from, p.pos)
+    }
+
+    args, err := p.parseFilterArgs(ESCAPE_FILTER)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := checkFilterArgs(args, 1, 1); err != nil {
+        return nil, p.fail(fmt.Errorf("filter \"%s\": %s", ESCAPE_FILTER,
+                                      err.Error()),
+                           from, from + len(ESCAPE_FILTER))
+    }
+
+    escaper, ok := predefineEscapers[args[0]]
+    if ok == false {
+        return nil, p.fail(fmt.Errorf("unknown escaper \"%s\"", args[0]),
+                           from, p.pos)
+    }
+
+    return escaper, nil
+}
+
+
+// escapeWith sets the escaper of the references which are written to the
+// output, including the ones in the conditional sections, the references
+// with their own escapers are not changed. The nested templates like the
+// words of operators and the arguments of functions are not escaped, since
+// they are escaped with the value of the enclosing reference.
+func (cv *ComplexValue) escapeWith(escaper Escaper) {
+    for i := 0; i < cv.size; i++ {
+        code := &cv.code[i]
+
+        switch code.kind {
+
+        case SCRIPT_PLAIN:
+
+        case SCRIPT_CONDITION:
+            code.condition.then.escapeWith(escaper)
+
+            if code.condition.otherwise != nil {
+                code.condition.otherwise.escapeWith(escaper)
+            }
+
+        default:
+            if code.escape == nil {
+                code.escape = escaper
+            }
+        }
+    }
+}
+
+
+// ParseWithEscaper is like Parse, but the values of references are escaped
+// by escaper when coding, e.g. EscapeJSON for the JSON log lines, while the
+// literal text of template is kept as is. A reference can still choose its
+// own escaper, like ${name|escape(html)}, or ${name|escape(none)} to write
+// the value as is.
+func (corgi *Corgi) ParseWithEscaper(text string,
+                                     escaper Escaper) (*ComplexValue, error) {
+
+    cv, err := corgi.Parse(text)
+    if err != nil {
+        return nil, err
+    }
+
+    if escaper != nil {
+        cv.escapeWith(escaper)
+    }
+
+    return cv, nil
+}
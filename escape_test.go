@@ -0,0 +1,161 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "testing"
+)
+
+
+var escapeValues map[string]string = map[string]string {
+    "quote" : "say \"hi\"\n",
+    "shell" : "it's; rm -rf /",
+    "comma" : "a,b",
+    "tag"   : "<b>&",
+    "query" : "a b&c=d",
+}
+
+
+func variableGetEscape(value *VariableValue, _ interface{},
+                       name string) error {
+
+    data, ok := escapeValues[name]
+
+    value.NotFound = ok == false
+    value.Cacheable = true
+    value.Value = data
+
+    return nil
+}
+
+
+func newEscapeCorgi(t *testing.T) *Corgi {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variable := &Variable {
+        Name  : "v_",
+        Get   : variableGetEscape,
+        Flags : VARIABLE_UNKNOWN,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testEscapeEscapers(t *testing.T) {
+    var tests = []struct {
+        escaper   Escaper
+        value     string
+        expected  string
+    } {
+        { EscapeJSON, "plain", "plain" },
+        { EscapeJSON, "say \"hi\"\n", "say \\\"hi\\\"\\n" },
+        { EscapeJSON, "a\\b\t\x01", "a\\\\b\\t\\u0001" },
+        { EscapeJSON, "中文\u2028", "中文\\u2028" },
+        { EscapeJSON, "bad\xff", "bad\\ufffd" },
+        { EscapeHTML, "<b>&\"'", "&lt;b&gt;&amp;&#34;&#39;" },
+        { EscapeShell, "it's", "'it'\\''s'" },
+        { EscapeShell, "", "''" },
+        { EscapeURL, "a b&c=d", "a+b%26c%3Dd" },
+        { EscapeCSV, "plain", "plain" },
+        { EscapeCSV, "a,\"b\"", "\"a,\"\"b\"\"\"" },
+        { EscapeCSV, " lead", "\" lead\"" },
+        { EscapeCSV, "", "" },
+    }
+
+    for _, test := range tests {
+        if result := test.escaper(test.value); result != test.expected {
+            t.Fatalf("incorrect escaped value of \"%s\", expected \"%s\" "+
+                     "but seen \"%s\"", test.value, test.expected, result)
+        }
+    }
+}
+
+
+func testEscapeTemplate(t *testing.T) {
+    c := newEscapeCorgi(t)
+
+    // the literal text is never escaped
+    text := "{\"msg\":\"$v_quote\",\"cmd\":\"${v_shell}\"," +
+            "\"word\":\"${v_none:-$v_quote}\",\"raw\":\"${v_tag|escape(none)}\"," +
+            "\"html\":\"${v_tag|escape(html)}\"$?{v_comma}{,\"c\":\"$v_comma\"}}"
+
+    cv, err := c.ParseWithEscaper(text, EscapeJSON)
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    expected := "{\"msg\":\"say \\\"hi\\\"\\n\",\"cmd\":\"it's; rm -rf /\"," +
+                "\"word\":\"say \\\"hi\\\"\\n\",\"raw\":\"<b>&\"," +
+                "\"html\":\"&lt;b&gt;&amp;\",\"c\":\"a,b\"}"
+
+    expectCode(t, c, cv, expected)
+
+    // the folded values are escaped as well
+    expectCode(t, c, c.Optimize(cv), expected)
+
+    cv, err = c.ParseWithEscaper("echo $v_shell \"$1\"", EscapeShell)
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    c.Group = []string { "", "a'b" }
+
+    expectCode(t, c, cv, "echo 'it'\\''s; rm -rf /' \"'a'\\''b'\"")
+
+    // the padding is escaped with the value
+    cv, err = c.ParseWithEscaper("${v_comma:<5},${v_tag|upper},x", EscapeCSV)
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "\"a,b  \",<B>&,x")
+}
+
+
+func testEscapeReference(t *testing.T) {
+    c := newEscapeCorgi(t)
+
+    cv, err := c.Parse("/?q=${v_query|escape(url)}&raw=$v_query")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    expectCode(t, c, cv, "/?q=a+b%26c%3Dd&raw=a b&c=d")
+
+    var failures = []struct {
+        text    string
+        reason  string
+    } {
+        { "${v_tag|escape(xml)}", "unknown escaper \"xml\"" },
+        { "${v_tag|escape}", "filter \"escape\": expects 1 argument(s) but 0 given" },
+        { "${v_tag|escape(json, html)}", "filter \"escape\": expects 1 argument(s) but 2 given" },
+    }
+
+    for _, failure := range failures {
+        if _, err := c.Parse(failure.text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", failure.text)
+
+        } else if err.Error() != failure.reason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    if err := c.RegisterFilter(ESCAPE_FILTER, predefineFilterUpper); err == nil {
+        t.Fatal("unexpected successful registering of filter \"escape\"")
+    }
+}
+
+
+func TestEscape(t *testing.T) {
+    testEscapeEscapers(t)
+    testEscapeTemplate(t)
+    testEscapeReference(t)
+}
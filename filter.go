@@ -0,0 +1,409 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+    "net/url"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/base64"
+)
+
+
+const (
+    FILTER_PIPE   = '|'
+    FILTER_LPAREN = '('
+    FILTER_RPAREN = ')'
+    FILTER_COMMA  = ','
+    FILTER_QUOTE  = '"'
+)
+
+
+// FilterFunc transforms a variable value, it will be invoked when coding.
+type FilterFunc func(value string) (string, error)
+
+// FilterHandler creates the FilterFunc with the filter arguments, it will be
+// invoked when parsing, so that a bad filter fails early.
+type FilterHandler func(args []string) (FilterFunc, error)
+
+
+var predefineFilters map[string]FilterHandler = map[string]FilterHandler {
+    "upper"     : predefineFilterUpper,
+    "lower"     : predefineFilterLower,
+    "trim"      : predefineFilterTrim,
+    "replace"   : predefineFilterReplace,
+    "truncate"  : predefineFilterTruncate,
+    "default"   : predefineFilterDefault,
+    "base64"    : predefineFilterBase64,
+    "urlencode" : predefineFilterURLEncode,
+    "sha256"    : predefineFilterSHA256,
+}
+
+
+// checkArgs checks the number of arguments, max is negative if there is no
+// upper limit.
+func checkArgs(n int, min int, max int) error {
+    if max < 0 {
+        if n < min {
+            return fmt.Errorf("expects at least %d argument(s) but %d given",
+                              min, n)
+        }
+
+        return nil
+    }
+
+    if n < min || n > max {
+        if min == max {
+            return fmt.Errorf("expects %d argument(s) but %d given", min, n)
+        }
+
+        return fmt.Errorf("expects %d to %d argument(s) but %d given", min,
+                          max, n)
+    }
+
+    return nil
+}
+
+
+func checkFilterArgs(args []string, min int, max int) error {
+    return checkArgs(len(args), min, max)
+}
+
+
+func predefineFilterUpper(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 0); err != nil {
+        return nil, err
+    }
+
+    return func(value string) (string, error) {
+        return strings.ToUpper(value), nil
+    }, nil
+}
+
+
+func predefineFilterLower(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 0); err != nil {
+        return nil, err
+    }
+
+    return func(value string) (string, error) {
+        return strings.ToLower(value), nil
+    }, nil
+}
+
+
+func predefineFilterTrim(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 1); err != nil {
+        return nil, err
+    }
+
+    if len(args) == 0 {
+        return func(value string) (string, error) {
+            return strings.TrimSpace(value), nil
+        }, nil
+    }
+
+    cutset := args[0]
+
+    return func(value string) (string, error) {
+        return strings.Trim(value, cutset), nil
+    }, nil
+}
+
+
+func predefineFilterReplace(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 2, 2); err != nil {
+        return nil, err
+    }
+
+    if args[0] == "" {
+        return nil, errors.New("empty string cannot be replaced")
+    }
+
+    replacer := strings.NewReplacer(args[0], args[1])
+
+    return func(value string) (string, error) {
+        return replacer.Replace(value), nil
+    }, nil
+}
+
+
+func predefineFilterTruncate(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 1, 1); err != nil {
+        return nil, err
+    }
+
+    n, err := strconv.Atoi(args[0])
+    if err != nil || n < 0 {
+        return nil, fmt.Errorf("invalid length \"%s\"", args[0])
+    }
+
+    return func(value string) (string, error) {
+        count := 0
+
+        for i := range value {
+            if count == n {
+                return value[:i], nil
+            }
+
+            count++
+        }
+
+        return value, nil
+    }, nil
+}
+
+
+func predefineFilterDefault(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 1, 1); err != nil {
+        return nil, err
+    }
+
+    word := args[0]
+
+    return func(value string) (string, error) {
+        if value == "" {
+            return word, nil
+        }
+
+        return value, nil
+    }, nil
+}
+
+
+func predefineFilterBase64(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 0); err != nil {
+        return nil, err
+    }
+
+    return func(value string) (string, error) {
+        return base64.StdEncoding.EncodeToString([]byte(value)), nil
+    }, nil
+}
+
+
+func predefineFilterURLEncode(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 0); err != nil {
+        return nil, err
+    }
+
+    return func(value string) (string, error) {
+        return url.QueryEscape(value), nil
+    }, nil
+}
+
+
+func predefineFilterSHA256(args []string) (FilterFunc, error) {
+    if err := checkFilterArgs(args, 0, 0); err != nil {
+        return nil, err
+    }
+
+    return func(value string) (string, error) {
+        sum := sha256.Sum256([]byte(value))
+        return hex.EncodeToString(sum[:]), nil
+    }, nil
+}
+
+
+// RegisterFilter registers a new filter, which can be used in the pipeline
+// of variable references, e.g. ${name|upper}.
+// An existing filter with the same name will be replaced, the already parsed
+// ComplexValue is not affected.
+// In case of failure, a corresponding error object will be yielded.
+func (corgi *Corgi) RegisterFilter(name string, handler FilterHandler) error {
+    if isValidName(name) == false {
+        return fmt.Errorf("invalid filter name \"%s\"", name)
+    }
+
+    if handler == nil {
+        return fmt.Errorf("nil handler for filter \"%s\"", name)
+    }
+
+    if name == ESCAPE_FILTER {
+        return fmt.Errorf("filter \"%s\" is reserved", name)
+    }
+
+    corgi.lock.Lock()
+    corgi.filters[name] = handler
+    corgi.lock.Unlock()
+
+    return nil
+}
+
+
+func (corgi *Corgi) lookupFilter(name string) (FilterHandler, bool) {
+    corgi.lock.RLock()
+    handler, ok := corgi.filters[name]
+    corgi.lock.RUnlock()
+
+    return handler, ok
+}
+
+
+func (corgi *Corgi) registerPredefineFilters() error {
+    for name, handler := range predefineFilters {
+        if err := corgi.RegisterFilter(name, handler); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+
+func (p *parser) skipSpaces() {
+    for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+        p.pos++
+    }
+}
+
+
+func (p *parser) parseFilterArg() (string, error) {
+    from := p.pos
+
+    if p.text[p.pos] == FILTER_QUOTE {
+        for p.pos++; p.pos < len(p.text); p.pos++ {
+            if p.text[p.pos] == '\\' {
+                p.pos++
+                continue
+            }
+
+            if p.text[p.pos] == FILTER_QUOTE {
+                p.pos++
+                return strconv.Unquote(p.text[from:p.pos])
+            }
+        }
+
+        err := errors.New("unexpected end of string, '\"' is missing")
+
+        return "", p.fail(newParseError(ErrUnterminated, err), from, from + 1)
+    }
+
+    for p.pos < len(p.text) {
+        if ch := p.text[p.pos]; ch == FILTER_COMMA || ch == FILTER_RPAREN {
+            break
+        }
+
+        p.pos++
+    }
+
+    return strings.TrimSpace(p.text[from:p.pos]), nil
+}
+
+
+func (p *parser) parseFilterArgs(name string) ([]string, error) {
+    var args []string
+
+    from := p.pos
+
+    // skips the left parenthesis
+    p.pos++
+    p.skipSpaces()
+
+    if p.pos < len(p.text) && p.text[p.pos] == FILTER_RPAREN {
+        p.pos++
+        return args, nil
+    }
+
+    for p.pos < len(p.text) {
+        arg, err := p.parseFilterArg()
+        if err != nil {
+            if e, ok := err.(*ParseError); ok == true {
+                e.Err = fmt.Errorf("filter \"%s\": %s", name, e.Err.Error())
+                return nil, e
+            }
+
+            return nil, fmt.Errorf("filter \"%s\": %s", name, err.Error())
+        }
+
+        args = append(args, arg)
+
+        p.skipSpaces()
+
+        if p.pos == len(p.text) {
+            break
+        }
+
+        ch := p.text[p.pos]
+        p.pos++
+
+        if ch == FILTER_RPAREN {
+            return args, nil
+        }
+
+        if ch != FILTER_COMMA {
+            err := fmt.Errorf("filter \"%s\": unexpected character "+
+                              "'%c' in arguments", name, ch)
+
+            return nil, p.fail(err, p.pos - 1, p.pos)
+        }
+
+        p.skipSpaces()
+    }
+
+    err := errors.New("unexpected end of string, \")\" is missing")
+
+    return nil, p.fail(newParseError(ErrUnterminated, err), from, from + 1)
+}
+
+
+// parseFilters parses the filter pipeline, like "|upper|replace(a, b)", the
+// filters are created immediately, so the bad ones fail when parsing.
+// The escaper of the reference, like "|escape(json)", is also parsed, which
+// is applied after all the filters.
+func (p *parser) parseFilters() ([]FilterFunc, Escaper, error) {
+    var filters []FilterFunc
+    var escaper  Escaper
+    var args    []string
+    var err      error
+
+    for p.pos < len(p.text) && p.text[p.pos] == FILTER_PIPE {
+        // skips the pipe
+        p.pos++
+
+        from := p.pos
+
+        name := p.parseName()
+        if name == "" {
+            return nil, nil, p.fail(errors.New("invalid filter name"), from,
+                                    from)
+        }
+
+        if name == ESCAPE_FILTER {
+            if escaper, err = p.parseEscaper(from); err != nil {
+                return nil, nil, err
+            }
+
+            continue
+        }
+
+        handler, ok := p.corgi.lookupFilter(name)
+        if ok == false {
+            return nil, nil, p.fail(fmt.Errorf("unknown filter \"%s\"", name),
+                                    from, p.pos)
+        }
+
+        args = nil
+
+        if p.pos < len(p.text) && p.text[p.pos] == FILTER_LPAREN {
+            if args, err = p.parseFilterArgs(name); err != nil {
+                return nil, nil, err
+            }
+        }
+
+        filter, err := handler(args)
+        if err != nil {
+            return nil, nil, p.fail(fmt.Errorf("filter \"%s\": %s", name,
+                                               err.Error()),
+                                    from, from + len(name))
+        }
+
+        filters = append(filters, filter)
+    }
+
+    return filters, escaper, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "errors"
+    "testing"
+)
+
+
+func filterReverse(args []string) (FilterFunc, error) {
+    if len(args) != 0 {
+        return nil, errors.New("no arguments expected")
+    }
+
+    return func(value string) (string, error) {
+        runes := []rune(value)
+
+        for i, j := 0, len(runes) - 1; i < j; i, j = i + 1, j - 1 {
+            runes[i], runes[j] = runes[j], runes[i]
+        }
+
+        return string(runes), nil
+    }, nil
+}
+
+
+func filterFailed(_ []string) (FilterFunc, error) {
+    return func(_ string) (string, error) {
+        return "", errors.New("intentional error")
+    }, nil
+}
+
+
+func testFilterPredefine(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    cases := map[string]string {
+        "${name|upper}"                      : "ALEX",
+        "${name|upper|lower}"                : "alex",
+        "${name|replace(\"le\", \"LE\")}"    : "aLEx",
+        "${name|replace(a, \"\")|upper}"     : "LEX",
+        "${name|truncate(2)}"                : "al",
+        "${name|truncate(10)}"               : "alex",
+        "${name|truncate( 0 )}"              : "",
+        "${name|trim(ax)}"                   : "le",
+        "${name|base64}"                     : "YWxleA==",
+        "${name|sha256|truncate(8)}"         : "4135aa9d",
+        "${env_corgi_xxxxx:-a b&c|urlencode}": "a+b%26c",
+        "${env_corgi_xxxxx:-|default(none)}" : "none",
+        "${env_corgi_xxxxx:-  x  |trim}"     : "x",
+        "${name:+\"$gender\"|upper}"         : "\"MALE\"",
+        "name|upper, ${name}|upper"          : "name|upper, alex|upper",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+}
+
+
+func testFilterRegister(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    if err := c.RegisterFilter("reverse", filterReverse); err != nil {
+        t.Fatalf("failed to register filter: %s", err.Error())
+    }
+
+    if err := c.RegisterFilter("failed", filterFailed); err != nil {
+        t.Fatalf("failed to register filter: %s", err.Error())
+    }
+
+    data := parse(t, c, "${name|reverse|upper}")
+    if data != "XELA" {
+        t.Fatalf("incorrect value, expected \"XELA\" but seen \"%s\"", data)
+    }
+
+    errorReason := "invalid filter name \"bad-name\""
+
+    if err := c.RegisterFilter("bad-name", filterReverse); err == nil {
+        t.Fatal("unexpected successful register")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    cv, err := c.Parse("${name|failed}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "intentional error" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testFilterParseFailed(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    cases := map[string]string {
+        "${name|}"                 : "invalid filter name",
+        "${name|xxxxx}"            : "unknown filter \"xxxxx\"",
+        "${name|upper(1)}"         : "filter \"upper\": expects 0 argument(s) but 1 given",
+        "${name|replace(a)}"       : "filter \"replace\": expects 2 argument(s) but 1 given",
+        "${name|trim(a, b)}"       : "filter \"trim\": expects 0 to 1 argument(s) but 2 given",
+        "${name|truncate(x)}"      : "filter \"truncate\": invalid length \"x\"",
+        "${name|truncate(1}"       : "unexpected end of string, \")\" is missing",
+        "${name|replace(\"a, b)}"  : "filter \"replace\": unexpected end of string, '\"' is missing",
+        "${name|replace(\"a\" b)}" : "filter \"replace\": unexpected character 'b' in arguments",
+        "${name|upper"             : "unexpected end of string, \"}\" is missing",
+        "${name|upper x}"          : "\"}\" for variable \"name\" is missing",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func TestFilter(t *testing.T) {
+    testFilterPredefine(t)
+    testFilterRegister(t)
+    testFilterParseFailed(t)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+
+// Optimize folds the static segments of cv, i.e. the variable references
+// whose values are cached(like $hostname and $pid), to the plain text, and
+// merges them with the neighbouring plain segments, so that the yielded
+// ComplexValue has fewer segments. cv itself is not changed.
+// The references with the filters, the operators, the conditions and the
+// others are kept, the unset or empty values are not folded since they may
+// be assigned by the ":=" operator.
+// Registering a variable after Optimize invalidates the folded segments, the
+// yielded ComplexValue falls back to cv then, and it can be optimized again.
+// A Session always codes cv, since the values are got with its own context.
+func (corgi *Corgi) Optimize(cv *ComplexValue) *ComplexValue {
+    if cv.source != nil {
+        // folds the original segments rather than the folded ones
+        cv = cv.source
+    }
+
+    folded := corgi.newComplexValue()
+
+    folded.source = cv
+    folded.generation = corgi.currentGeneration()
+
+    for i := 0; i < cv.size; i++ {
+        code := cv.code[i]
+
+        if value, ok := corgi.foldValue(&code); ok {
+            code = scriptCode {
+                kind : SCRIPT_PLAIN,
+                data : value,
+            }
+        }
+
+        last := len(folded.code) - 1
+
+        if code.kind == SCRIPT_PLAIN && last >= 0 &&
+           folded.code[last].kind == SCRIPT_PLAIN {
+
+            folded.code[last].data += code.data
+            continue
+        }
+
+        folded.code = append(folded.code, code)
+        folded.size++
+    }
+
+    return folded
+}
+
+
+// foldValue evaluates the static segment code, ok is false if code is not
+// static.
+func (corgi *Corgi) foldValue(code *scriptCode) (string, bool) {
+    ref := code.ref
+
+    if code.kind != SCRIPT_VARIABLE || code.name != nil ||
+       len(code.filters) > 0 || ref == nil {
+
+        return "", false
+    }
+
+    binding := ref.bound(corgi, code.data)
+
+    if binding == nil ||
+       (binding.variable.Flags & VARIABLE_NO_CACHEABLE) != 0 {
+
+        return "", false
+    }
+
+    c := corgi.newCoder(corgi.Context, corgi.Group)
+    value, err := c.valueOf(ref, code.data)
+    c.release()
+
+    if err != nil || value.Cacheable == false || value.NotFound ||
+       value.Value == "" {
+
+        return "", false
+    }
+
+    result := value.Value
+
+    if code.format != nil {
+        if result, err = code.format.string(result); err != nil {
+            return "", false
+        }
+    }
+
+    if code.escape != nil {
+        result = code.escape(result)
+    }
+
+    return result, true
+}
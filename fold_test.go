@@ -0,0 +1,161 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "os"
+    "fmt"
+    "testing"
+)
+
+
+var folded int
+
+
+func variableGetFolded(value *VariableValue, _ interface{}, name string) error {
+    folded++
+
+    value.NotFound = false
+    value.Cacheable = true
+    value.Value = name
+
+    if name == "empty" {
+        value.Value = ""
+    }
+
+    return nil
+}
+
+
+func newFoldCorgi(t *testing.T) *Corgi {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variables := []*Variable {
+        &Variable {
+            Name  : "static",
+            Get   : variableGetFolded,
+            Flags : VARIABLE_CHANGEABLE,
+        },
+
+        &Variable {
+            Name  : "empty",
+            Get   : variableGetFolded,
+        },
+
+        &Variable {
+            Name  : "dynamic",
+            Get   : variableGetFolded,
+            Flags : VARIABLE_NO_CACHEABLE,
+        },
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testFoldStatic(t *testing.T) {
+    c := newFoldCorgi(t)
+
+    hostname, err := os.Hostname()
+    if err != nil {
+        t.Fatalf("failed to get hostname: %s", err.Error())
+    }
+
+    text := "host=$hostname pid=$pid [${static:>8}] $dynamic ${static|upper}"
+    expected := fmt.Sprintf("host=%s pid=%d [  static] dynamic STATIC",
+                            hostname, os.Getpid())
+
+    cv, err := c.Parse(text)
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    optimized := c.Optimize(cv)
+
+    // "host=... [  static] ", "$dynamic", " ", "${static|upper}"
+    if optimized.size != 4 || cv.size != 10 {
+        t.Fatalf("unexpected %d segments folded from %d", optimized.size,
+                 cv.size)
+    }
+
+    expectCode(t, c, optimized, expected)
+    expectCode(t, c, cv, expected)
+
+    count := folded
+
+    expectCode(t, c, optimized, expected)
+
+    // only $dynamic is got again
+    if folded != count + 1 {
+        t.Fatalf("unexpected %d calls of the get handler", folded - count)
+    }
+
+    // the empty value may be assigned in the coding
+    cv, err = c.Parse("${empty:=set}[$empty]")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    optimized = c.Optimize(cv)
+
+    if optimized.size != 4 {
+        t.Fatalf("unexpected %d segments folded", optimized.size)
+    }
+
+    expectCode(t, c, optimized, "set[set]")
+
+    // the assigned value is not cached for the later codings
+    expectCode(t, c, c.Optimize(optimized), "set[set]")
+}
+
+
+func testFoldInvalidate(t *testing.T) {
+    c := newFoldCorgi(t)
+
+    cv, err := c.Parse("<$static>")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    optimized := c.Optimize(cv)
+
+    if optimized.size != 1 {
+        t.Fatalf("unexpected %d segments folded", optimized.size)
+    }
+
+    expectCode(t, c, optimized, "<static>")
+
+    variable := &Variable {
+        Name : "static",
+        Get  : variableGetUpperName,
+    }
+
+    if err := c.RegisterNewVariable(variable); err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    // falls back to the unfolded segments
+    expectCode(t, c, optimized, "<<static>>")
+
+    // the uncacheable value is not folded
+    optimized = c.Optimize(optimized)
+
+    if optimized.size != 3 {
+        t.Fatalf("unexpected %d segments folded", optimized.size)
+    }
+
+    expectCode(t, c, optimized, "<<static>>")
+}
+
+
+func TestFold(t *testing.T) {
+    testFoldStatic(t)
+    testFoldInvalidate(t)
+}
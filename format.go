@@ -0,0 +1,342 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "io"
+    "fmt"
+    "unicode"
+)
+
+
+const (
+    FORMAT_LEFT     = '<'
+    FORMAT_RIGHT    = '>'
+    FORMAT_CENTER   = '^'
+    FORMAT_ZERO     = '0'
+    FORMAT_MAX      = '.'
+    FORMAT_ELLIPSIS = '~'
+
+    FORMAT_MAX_WIDTH = 1024
+
+    FORMAT_SPACES = "                                "
+    FORMAT_ZEROS  = "00000000000000000000000000000000"
+)
+
+
+// scriptFormat describes the width control of a variable value, e.g.
+// ${name:>20.10~}, max is -1 if the value will not be truncated.
+type scriptFormat struct {
+    align     byte
+    zero      bool
+    width     int
+    max       int
+    ellipsis  bool
+}
+
+
+// the East Asian wide and fullwidth characters, which occupy two columns.
+var wideRunes [][2]rune = [][2]rune {
+    { 0x1100, 0x115F },
+    { 0x231A, 0x231B },
+    { 0x2329, 0x232A },
+    { 0x2E80, 0x303E },
+    { 0x3041, 0x33FF },
+    { 0x3400, 0x4DBF },
+    { 0x4E00, 0x9FFF },
+    { 0xA000, 0xA4CF },
+    { 0xA960, 0xA97F },
+    { 0xAC00, 0xD7A3 },
+    { 0xF900, 0xFAFF },
+    { 0xFE10, 0xFE19 },
+    { 0xFE30, 0xFE6F },
+    { 0xFF00, 0xFF60 },
+    { 0xFFE0, 0xFFE6 },
+    { 0x1F300, 0x1F64F },
+    { 0x1F900, 0x1F9FF },
+    { 0x20000, 0x2FFFD },
+    { 0x30000, 0x3FFFD },
+}
+
+
+// runeWidth returns the number of display columns that ch occupies.
+func runeWidth(ch rune) int {
+    if ch < 0x20 || (ch >= 0x7F && ch < 0xA0) {
+        return 0
+    }
+
+    if ch < 0x7F {
+        // the printable ASCII characters, which are the most common
+        return 1
+    }
+
+    if unicode.In(ch, unicode.Mn, unicode.Me, unicode.Cf) {
+        return 0
+    }
+
+    lo, hi := 0, len(wideRunes) - 1
+
+    for lo <= hi {
+        mid := (lo + hi) / 2
+
+        if ch < wideRunes[mid][0] {
+            hi = mid - 1
+
+        } else if ch > wideRunes[mid][1] {
+            lo = mid + 1
+
+        } else {
+            return 2
+        }
+    }
+
+    return 1
+}
+
+
+func stringWidth(s string) int {
+    width := 0
+
+    for _, ch := range s {
+        width += runeWidth(ch)
+    }
+
+    return width
+}
+
+
+// isFormat checks whether the text after a colon is a format rather than an
+// operator, a format always starts with the alignment, the zero-fill flag
+// (followed by the width) or the max width.
+func (p *parser) isFormat() bool {
+    if p.pos + 2 >= len(p.text) || p.text[p.pos] != VARIABLE_COLON {
+        return false
+    }
+
+    next := p.text[p.pos + 1]
+
+    switch (next) {
+
+    case FORMAT_LEFT, FORMAT_RIGHT, FORMAT_CENTER:
+        return true
+
+    case FORMAT_ZERO, FORMAT_MAX:
+        ch := p.text[p.pos + 2]
+        return ch >= '0' && ch <= '9'
+    }
+
+    return false
+}
+
+
+func (p *parser) parseNumber() (int, bool) {
+    n := 0
+    from := p.pos
+
+    for p.pos < len(p.text) {
+        ch := p.text[p.pos]
+        if ch < '0' || ch > '9' {
+            break
+        }
+
+        // caps the number, the caller will reject it
+        if n <= FORMAT_MAX_WIDTH {
+            n = n * 10 + int(ch - '0')
+        }
+
+        p.pos++
+    }
+
+    return n, p.pos > from
+}
+
+
+func (p *parser) parseFormat(name string) (*scriptFormat, error) {
+    var format *scriptFormat = &scriptFormat {
+        max : -1,
+    }
+
+    // skips the colon
+    p.pos++
+    from := p.pos
+
+    if p.pos < len(p.text) {
+        switch (p.text[p.pos]) {
+
+        case FORMAT_LEFT, FORMAT_RIGHT, FORMAT_CENTER:
+            format.align = p.text[p.pos]
+            p.pos++
+        }
+    }
+
+    if p.pos < len(p.text) && p.text[p.pos] == FORMAT_ZERO {
+        format.zero = true
+        p.pos++
+    }
+
+    format.width, _ = p.parseNumber()
+
+    if p.pos < len(p.text) && p.text[p.pos] == FORMAT_MAX {
+        p.pos++
+
+        max, ok := p.parseNumber()
+        if ok == false {
+            return nil, fmt.Errorf("invalid format for variable \"%s\"", name)
+        }
+
+        format.max = max
+
+        if p.pos < len(p.text) && p.text[p.pos] == FORMAT_ELLIPSIS {
+            format.ellipsis = true
+            p.pos++
+        }
+    }
+
+    if p.pos == from {
+        return nil, fmt.Errorf("invalid format for variable \"%s\"", name)
+    }
+
+    if format.width > FORMAT_MAX_WIDTH || format.max > FORMAT_MAX_WIDTH {
+        return nil, fmt.Errorf("too large width for variable \"%s\"", name)
+    }
+
+    if format.align == 0 {
+        if format.zero {
+            format.align = FORMAT_RIGHT
+
+        } else {
+            format.align = FORMAT_LEFT
+        }
+    }
+
+    return format, nil
+}
+
+
+// truncate cuts value so that it occupies at most max columns, the
+// returned value is a substring of value, and the columns it occupies.
+func (format *scriptFormat) truncate(value string) (string, int, bool) {
+    width := stringWidth(value)
+
+    if format.max < 0 || width <= format.max {
+        return value, width, false
+    }
+
+    max := format.max
+    if format.ellipsis && max > 0 {
+        // reserves a column for the ellipsis
+        max--
+    }
+
+    width = 0
+
+    for i, ch := range value {
+        w := runeWidth(ch)
+        if width + w > max {
+            return value[:i], width, true
+        }
+
+        width += w
+    }
+
+    return value, width, true
+}
+
+
+// writePadding writes n fill characters to w, in chunks rather than byte by
+// byte, since w may be a file or a socket.
+func writePadding(w io.Writer, fill byte, n int) error {
+    padding := FORMAT_SPACES
+    if fill == FORMAT_ZERO {
+        padding = FORMAT_ZEROS
+    }
+
+    for n > 0 {
+        size := n
+        if size > len(padding) {
+            size = len(padding)
+        }
+
+        if err := writeString(w, padding[:size]); err != nil {
+            return err
+        }
+
+        n -= size
+    }
+
+    return nil
+}
+
+
+// string returns the formatted value.
+func (format *scriptFormat) string(value string) (string, error) {
+    var writer *appendWriter = new(appendWriter)
+
+    if err := format.write(writer, value); err != nil {
+        return "", err
+    }
+
+    return string(writer.buffer), nil
+}
+
+
+// write writes the formatted value to w without the extra allocations.
+func (format *scriptFormat) write(w io.Writer, value string) error {
+    var fill      byte = ' '
+    var sign      string
+
+    value, width, truncated := format.truncate(value)
+
+    ellipsis := truncated && format.ellipsis && format.max > 0
+    if ellipsis {
+        width++
+    }
+
+    if format.zero {
+        fill = '0'
+
+        if format.align == FORMAT_RIGHT && value != "" &&
+           (value[0] == '-' || value[0] == '+') {
+
+            sign = value[:1]
+            value = value[1:]
+        }
+    }
+
+    pad := format.width - width
+    if pad < 0 {
+        pad = 0
+    }
+
+    left, right := 0, pad
+
+    switch (format.align) {
+
+    case FORMAT_RIGHT:
+        left, right = pad, 0
+
+    case FORMAT_CENTER:
+        left = pad / 2
+        right = pad - left
+    }
+
+    if err := writeString(w, sign); err != nil {
+        return err
+    }
+
+    if err := writePadding(w, fill, left); err != nil {
+        return err
+    }
+
+    if err := writeString(w, value); err != nil {
+        return err
+    }
+
+    if ellipsis {
+        if err := writeString(w, "…"); err != nil {
+            return err
+        }
+    }
+
+    return writePadding(w, fill, right)
+}
@@ -0,0 +1,107 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "strings"
+    "testing"
+)
+
+
+func testFormatWidth(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    c.Group = []string { "", "世界", "-42", "héllo" }
+
+    cases := map[string]string {
+        "[${name:>8}]"                     : "[    alex]",
+        "[${name:<8}]"                     : "[alex    ]",
+        "[${name:^9}]"                     : "[  alex   ]",
+        "[${name:>2}]"                     : "[alex]",
+        "[${weight:06}]"                   : "[000140]",
+        "[${2:06}]"                        : "[-00042]",
+        "[${2:<06}]"                       : "[-42000]",
+        "[${1:>6}]"                        : "[  世界]",
+        "[${1:^8}]"                        : "[  世界  ]",
+        "[${3:>6}]"                        : "[ héllo]",
+        "[${name:.2}]"                     : "[al]",
+        "[${name:>6.3}]"                   : "[   ale]",
+        "[${name:<6.3~}]"                  : "[al…   ]",
+        "[${name:.4~}]"                    : "[alex]",
+        "[${1:.3}]"                        : "[世]",
+        "[${1:<4.3~}]"                     : "[世… ]",
+        "[${name|upper:>6}]"               : "[  ALEX]",
+        "[${name:>40}]"                    : "[" + strings.Repeat(" ", 36) + "alex]",
+        "[${weight:040}]"                  : "[" + strings.Repeat("0", 37) + "140]",
+        "[${env_corgi_xxxxx:-x|upper:>3}]" : "[  X]",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+}
+
+
+func testFormatParseFailed(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    cases := map[string]string {
+        "${name:>8x}"        : "\"}\" for variable \"name\" is missing",
+        "${name:>.}"         : "invalid format for variable \"name\"",
+        "${name|upper:}"     : "invalid format for variable \"name\"",
+        "${name:>100000}"    : "too large width for variable \"name\"",
+        "${name:x}"          : "unknown operator \":x\" for variable \"name\"",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testFormatRuneWidth(t *testing.T) {
+    cases := map[string]int {
+        "alex"     : 4,
+        "世界"     : 4,
+        "한국"     : 4,
+        "ｆｕｌｌ" : 8,
+        "é"  : 1,
+        ""         : 0,
+    }
+
+    for s, expected := range cases {
+        if width := stringWidth(s); width != expected {
+            t.Fatalf("incorrect width of \"%s\", expected %d but seen %d",
+                     s, expected, width)
+        }
+    }
+}
+
+
+func TestFormat(t *testing.T) {
+    testFormatWidth(t)
+    testFormatParseFailed(t)
+    testFormatRuneWidth(t)
+}
@@ -0,0 +1,270 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "strings"
+)
+
+
+const (
+    FUNCTION_VARIADIC = -1
+)
+
+
+type FunctionHandler func(ctx interface{}, args []string) (string, error)
+
+
+// Function describles a function, which can be called in templates, e.g.
+// $join(",", $env_A, $env_B).
+// Name, function's name.
+// Call, the handler, which will be invoked when coding.
+// MinArgs, the minimum number of arguments.
+// MaxArgs, the maximum number of arguments, FUNCTION_VARIADIC if unlimited.
+type Function struct {
+    Name     string
+    Call     FunctionHandler
+    MinArgs  int
+    MaxArgs  int
+}
+
+
+type scriptFunction struct {
+    function *Function
+    args      []*ComplexValue
+}
+
+
+var predefineFunctions []*Function = []*Function {
+    &Function {
+        Name    : "lower",
+        Call    : predefineFunctionLower,
+        MinArgs : 1,
+        MaxArgs : 1,
+    },
+
+    &Function {
+        Name    : "upper",
+        Call    : predefineFunctionUpper,
+        MinArgs : 1,
+        MaxArgs : 1,
+    },
+
+    &Function {
+        Name    : "trim",
+        Call    : predefineFunctionTrim,
+        MinArgs : 1,
+        MaxArgs : 1,
+    },
+
+    &Function {
+        Name    : "join",
+        Call    : predefineFunctionJoin,
+        MinArgs : 1,
+        MaxArgs : FUNCTION_VARIADIC,
+    },
+
+    &Function {
+        Name    : "now",
+        Call    : predefineFunctionNow,
+        MinArgs : 0,
+        MaxArgs : 1,
+    },
+}
+
+
+func predefineFunctionLower(_ interface{}, args []string) (string, error) {
+    return strings.ToLower(args[0]), nil
+}
+
+
+func predefineFunctionUpper(_ interface{}, args []string) (string, error) {
+    return strings.ToUpper(args[0]), nil
+}
+
+
+func predefineFunctionTrim(_ interface{}, args []string) (string, error) {
+    return strings.TrimSpace(args[0]), nil
+}
+
+
+func predefineFunctionJoin(_ interface{}, args []string) (string, error) {
+    return strings.Join(args[1:], args[0]), nil
+}
+
+
+func predefineFunctionNow(_ interface{}, args []string) (string, error) {
+    layout := "02/Jan/2006:15:04:05 -0700"
+
+    if len(args) > 0 {
+        layout = args[0]
+    }
+
+    return time.Now().Format(layout), nil
+}
+
+
+// RegisterFunction registers a new function.
+// The unique param is the function that caller wants to register, an
+// existing function with the same name will be replaced, the already parsed
+// ComplexValue is not affected.
+// In case of failure, a corresponding error object will be yielded.
+func (corgi *Corgi) RegisterFunction(function *Function) error {
+    if isValidName(function.Name) == false {
+        return fmt.Errorf("invalid function name \"%s\"", function.Name)
+    }
+
+    if function.Call == nil {
+        return fmt.Errorf("nil handler for function \"%s\"", function.Name)
+    }
+
+    if function.MinArgs < 0 ||
+       (function.MaxArgs >= 0 && function.MaxArgs < function.MinArgs) {
+
+        return fmt.Errorf("invalid arity of function \"%s\"", function.Name)
+    }
+
+    corgi.lock.Lock()
+    corgi.functions[function.Name] = function
+    corgi.lock.Unlock()
+
+    return nil
+}
+
+
+func (corgi *Corgi) lookupFunction(name string) *Function {
+    corgi.lock.RLock()
+    function := corgi.functions[name]
+    corgi.lock.RUnlock()
+
+    return function
+}
+
+
+func (corgi *Corgi) registerPredefineFunctions() error {
+    for _, function := range predefineFunctions {
+        if err := corgi.RegisterFunction(function); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+
+// parseFunctionArg parses an argument, which is either a variable reference
+// (including the function call), a quoted string or a bare literal.
+func (p *parser) parseFunctionArg() (*ComplexValue, error) {
+    arg := p.corgi.newComplexValue()
+
+    if p.atOpener() {
+        if err := p.parseVariable(arg); err != nil {
+            return nil, err
+        }
+
+        return arg, nil
+    }
+
+    literal, err := p.parseFilterArg()
+    if err != nil {
+        return nil, err
+    }
+
+    if err := arg.append(literal, false); err != nil {
+        return nil, err
+    }
+
+    return arg, nil
+}
+
+
+func (p *parser) parseFunction(cv *ComplexValue, function *Function) error {
+    var call *scriptFunction = &scriptFunction {
+        function : function,
+    }
+
+    from := p.pos
+
+    // skips the left parenthesis
+    p.pos++
+    p.skipSpaces()
+
+    if p.pos < len(p.text) && p.text[p.pos] == FILTER_RPAREN {
+        p.pos++
+        return cv.appendFunction(call)
+    }
+
+    for p.pos < len(p.text) {
+        arg, err := p.parseFunctionArg()
+        if err != nil {
+            return err
+        }
+
+        call.args = append(call.args, arg)
+
+        p.skipSpaces()
+
+        if p.pos == len(p.text) {
+            break
+        }
+
+        ch := p.text[p.pos]
+        p.pos++
+
+        if ch == FILTER_RPAREN {
+            return cv.appendFunction(call)
+        }
+
+        if ch != FILTER_COMMA {
+            err := fmt.Errorf("function \"%s\": unexpected character '%c' in "+
+                              "arguments", function.Name, ch)
+
+            return p.fail(err, p.pos - 1, p.pos)
+        }
+
+        p.skipSpaces()
+    }
+
+    err := errors.New("unexpected end of string, \")\" is missing")
+
+    return p.fail(newParseError(ErrUnterminated, err), from, from + 1)
+}
+
+
+func (cv *ComplexValue) appendFunction(call *scriptFunction) error {
+    function := call.function
+
+    err := checkArgs(len(call.args), function.MinArgs, function.MaxArgs)
+    if err != nil {
+        return fmt.Errorf("function \"%s\": %s", function.Name, err.Error())
+    }
+
+    cv.code = append(cv.code, scriptCode {
+        kind     : SCRIPT_FUNCTION,
+        data     : function.Name,
+        function : call,
+    })
+
+    cv.size++
+
+    return nil
+}
+
+
+func (c *coder) functionCall(code *scriptCode) (string, error) {
+    var args []string = make([]string, len(code.function.args))
+
+    for i, arg := range code.function.args {
+        value, err := c.codeString(arg)
+        if err != nil {
+            return "", err
+        }
+
+        args[i] = value
+    }
+
+    return code.function.function.Call(c.ctx, args)
+}
@@ -0,0 +1,134 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "time"
+    "errors"
+    "testing"
+)
+
+
+func functionContext(ctx interface{}, args []string) (string, error) {
+    prefix, ok := ctx.(string)
+    if ok == false {
+        return "", errors.New("unexpected context")
+    }
+
+    return prefix + args[0], nil
+}
+
+
+func newFunctionCorgi(t *testing.T) *Corgi {
+    c := newOperatorCorgi(t)
+
+    err := c.RegisterFunction(&Function {
+        Name    : "prefix",
+        Call    : functionContext,
+        MinArgs : 1,
+        MaxArgs : 1,
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register function: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testFunctionCall(t *testing.T) {
+    c := newFunctionCorgi(t)
+
+    c.Context = "ctx-"
+
+    cases := map[string]string {
+        "$upper($set)"                          : "VALUE",
+        "$lower(\"ABC\")"                       : "abc",
+        "$trim(\"  x  \")!"                     : "x!",
+        "$join(\",\", $set, ${null:-x}, abc)"   : "value,x,abc",
+        "$join( - , a , b )"                    : "a-b",
+        "$join(\"\")"                           : "",
+        "$upper($join(\"-\", $set, $set))"      : "VALUE-VALUE",
+        "$prefix($set)"                         : "ctx-value",
+        "$set(1)"                               : "value(1)",
+        "$$upper($set)"                         : "$upper(value)",
+    }
+
+    err := c.RegisterNewVariable(&Variable {
+        Name : "upper",
+        Get  : variableUnknownFirst,
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    // the variable is used if the name is not followed by "("
+    cases["${upper}($set)"] = "first(value)"
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    expected := time.Now().Format("2006")
+    if data := parse(t, c, "$now(\"2006\")"); data != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 data)
+    }
+}
+
+
+func testFunctionParseFailed(t *testing.T) {
+    c := newFunctionCorgi(t)
+
+    cases := map[string]string {
+        "$upper()"            : "function \"upper\": expects 1 argument(s) but 0 given",
+        "$upper(a, b)"        : "function \"upper\": expects 1 argument(s) but 2 given",
+        "$join()"             : "function \"join\": expects at least 1 argument(s) but 0 given",
+        "$now(a, b)"          : "function \"now\": expects 0 to 1 argument(s) but 2 given",
+        "$upper($xxxxx)"      : "unknown variable \"xxxxx\"",
+        "$upper($set"         : "unexpected end of string, \")\" is missing",
+        "$upper($set x)"      : "function \"upper\": unexpected character 'x' in arguments",
+        "$upper(\"x)"         : "unexpected end of string, '\"' is missing",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    cases = map[string]string {
+        "bad-name" : "invalid function name \"bad-name\"",
+        "prefix"   : "invalid arity of function \"prefix\"",
+    }
+
+    for name, errorReason := range cases {
+        err := c.RegisterFunction(&Function {
+            Name    : name,
+            Call    : functionContext,
+            MinArgs : 2,
+            MaxArgs : 1,
+        })
+
+        if err == nil {
+            t.Fatal("unexpected successful register")
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func TestFunction(t *testing.T) {
+    testFunctionCall(t)
+    testFunctionParseFailed(t)
+}
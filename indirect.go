@@ -0,0 +1,83 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "strconv"
+)
+
+
+const (
+    VARIABLE_MAX_DEPTH = 32
+)
+
+
+// parseIndirectName parses the variable name which is computed from other
+// variables, e.g. ${env_${service}_PORT}, the name starts at from.
+func (p *parser) parseIndirectName(from int) (*ComplexValue, error) {
+    name := p.corgi.newComplexValue()
+
+    if p.pos > from {
+        if err := name.append(p.text[from:p.pos], false); err != nil {
+            return nil, err
+        }
+    }
+
+    for p.atIndirect() {
+        if err := p.parseVariable(name); err != nil {
+            return nil, err
+        }
+
+        if part := p.parsePathPart(); part != "" {
+            if err := name.append(part, false); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    return name, nil
+}
+
+
+// indirectName evaluates the name of the indirect reference, and checks it
+// as what Corgi.Parse does for the plain names.
+func (c *coder) indirectName(code *scriptCode) (string, error) {
+    name, err := c.codeString(code.name)
+    if err != nil {
+        return "", err
+    }
+
+    if isValidPath(name) == false {
+        return "", fmt.Errorf("invalid variable name \"%s\" from \"%s\"", name,
+                              code.data)
+    }
+
+    if n, err := strconv.Atoi(name); err == nil {
+        if n > 99 {
+            return "", fmt.Errorf("too large capture group number: \"%d\"", n)
+        }
+
+        return name, nil
+    }
+
+    root, _ := splitPath(name)
+
+    if variable, _, _ := c.corgi.lookupVariable(root); variable == nil {
+        return "", fmt.Errorf("unknown variable \"%s\" from \"%s\"", name,
+                              code.data)
+    }
+
+    return name, nil
+}
+
+
+// indirectGet gets the value of the evaluated name, which can be either a
+// variable or a capture group.
+func (c *coder) indirectGet(name string) (string, error) {
+    if isCaptureName(name) {
+        return c.captureGet(nil, name)
+    }
+
+    return c.variableGet(nil, name)
+}
@@ -0,0 +1,169 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "os"
+    "strings"
+    "testing"
+)
+
+
+type recursiveContext struct {
+    corgi    *Corgi
+    session  *Session
+    cv       *ComplexValue
+}
+
+
+// variableRecursiveGet codes the template again with the session, since the
+// recursion is not detected across the codings of Corgi.
+func variableRecursiveGet(value *VariableValue, ctx interface{}, _ string) error {
+    rc := ctx.(*recursiveContext)
+
+    result, err := rc.session.Code(rc.cv)
+    if err != nil {
+        return err
+    }
+
+    value.Value = result
+    value.NotFound = false
+    value.Cacheable = false
+
+    return nil
+}
+
+
+func testIndirectName(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    os.Setenv("CORGI_VALUE_PORT", "8080")
+    c.Group = []string { "", "first" }
+
+    err := c.RegisterNewVariable(&Variable {
+        Name  : "service",
+        Get   : variableUnknownFirst,
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cases := map[string]string {
+        "${env_CORGI_${set|upper}_PORT}"       : "8080",
+        "${env_CORGI_${set|upper}_HOST:-none}" : "none",
+        "${${service:3:1}et}"                  : "value",
+        "${s$null$null${null}et}"              : "value",
+        "${${set:0:0}1|upper}"                 : "FIRST",
+        "${$null${null}set:>6}"                : " value",
+        "${env_${unset:-CORGI_VALUE}_PORT}"    : "8080",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    cases = map[string]string {
+        "${xx${set}}"      : "unknown variable \"xxvalue\" from \"xx${set}\"",
+        "${${null}}"       : "invalid variable name \"\" from \"${null}\"",
+        "${x${path}}"      : "invalid variable name \"x/usr/local/lib/libcorgi.so.1\" from \"x${path}\"",
+        "${${unset}}"      : "vlaue of variable \"unset\" not found",
+        "${${set:0:0}2}"   : "too large capture number",
+    }
+
+    for text, errorReason := range cases {
+        cv, err := c.Parse(text)
+        if err != nil {
+            t.Fatalf("failed to parse \"%s\": %s", text, err.Error())
+        }
+
+        if _, err := c.Code(cv); err == nil {
+            t.Fatalf("unexpected successful coding of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testIndirectDepth(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    text := strings.Repeat("${unset:-", 16) + "x" + strings.Repeat("}", 16)
+
+    if data := parse(t, c, text); data != "x" {
+        t.Fatalf("incorrect value, expected \"x\" but seen \"%s\"", data)
+    }
+
+    text = strings.Repeat("${unset:-", 64) + "x" + strings.Repeat("}", 64)
+
+    if _, err := c.Parse(text); err == nil {
+        t.Fatal("unexpected successful parsing")
+
+    } else if err.Error() != "too deep nested variables" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    text = strings.Repeat("${env_", 64) + "x" + strings.Repeat("}", 64)
+
+    if _, err := c.Parse(text); err == nil {
+        t.Fatal("unexpected successful parsing")
+
+    } else if err.Error() != "too deep nested variables" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testIndirectRecursive(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    err := c.RegisterNewVariable(&Variable {
+        Name  : "self",
+        Get   : variableRecursiveGet,
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    cv, err := c.Parse("${self}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    rc := &recursiveContext {
+        corgi : c,
+        cv    : cv,
+    }
+
+    rc.session = c.NewSession(rc)
+    defer rc.session.Release()
+
+    c.Context = rc
+
+    errorReason := "variable \"self\" is referenced recursively"
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the resolving state must be restored
+    if data := parse(t, c, "$set"); data != "value" {
+        t.Fatalf("incorrect value, expected \"value\" but seen \"%s\"", data)
+    }
+}
+
+
+func TestIndirect(t *testing.T) {
+    testIndirectName(t)
+    testIndirectDepth(t)
+    testIndirectRecursive(t)
+}
@@ -0,0 +1,56 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "regexp"
+)
+
+
+// WithLenient lets Corgi.Parse keep the references to unknown variables as
+// the plain text in their original spelling, e.g. "${foo:-x}", so that the
+// result can be expanded by other tools. If pattern is not nil, only the
+// unknown variables whose names match it are kept, the others still fail
+// the parsing.
+func WithLenient(pattern *regexp.Regexp) Option {
+    return func(corgi *Corgi) error {
+        corgi.lenient = true
+        corgi.passes = pattern
+
+        return nil
+    }
+}
+
+
+// unknownVariable handles err, which is reported for the variable name at
+// the offset at, the reference starts at from and ends at the current
+// position. The unknown variable is kept as the plain text in lenient mode.
+func (p *parser) unknownVariable(cv *ComplexValue, err error, from, at int,
+                                 name string) error {
+
+    e, ok := err.(*ParseError)
+
+    if ok == false || e.Kind != ErrUnknownVariable || p.corgi.lenient == false {
+        return p.fail(err, at, at + len(name))
+    }
+
+    if p.corgi.passes != nil && p.corgi.passes.MatchString(name) == false {
+        return p.fail(err, at, at + len(name))
+    }
+
+    p.kept = true
+
+    return cv.append(p.text[from:p.pos], false)
+}
+
+
+// keepReference keeps the reference, which starts at from and ends at the
+// current position, as the plain text, since an unknown variable nested in
+// its name or operands(like ${env_${foo}} and $(( $foo + 1 ))) is kept, and
+// the reference can never be coded.
+func (p *parser) keepReference(cv *ComplexValue, from int) error {
+    p.kept = true
+
+    return cv.append(p.text[from:p.pos], false)
+}
+
@@ -0,0 +1,92 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "regexp"
+    "testing"
+)
+
+
+func testLenientAll(t *testing.T) {
+    c := newOperatorCorgi(t, WithLenient(nil))
+
+    cases := map[string]string {
+        "a $foo b"                  : "a $foo b",
+        "$foo$set"                  : "$foovalue",
+        "${foo}"                    : "${foo}",
+        "${foo:-$set}"              : "${foo:-$set}",
+        "${foo|upper:>8}"           : "${foo|upper:>8}",
+        "${set:-$foo}"              : "value",
+        "${unset:-$foo}"            : "$foo",
+        "$?{foo}{x$set}{y}!"        : "$?{foo}{x$set}{y}!",
+        "$upper($foo)"              : "$FOO",
+        "$$foo"                     : "$foo",
+        "${env_CORGI_XXXXX:-x}"     : "x",
+        "${env_${foo}}"             : "${env_${foo}}",
+        "${env_${foo}_PORT:-x}"     : "${env_${foo}_PORT:-x}",
+        "${a_${b_${foo}}}$set"      : "${a_${b_${foo}}}value",
+        "$(( $foo + 1 ))"           : "$(( $foo + 1 ))",
+        "$(( foo * ${set:-1} ))"    : "$(( foo * ${set:-1} ))",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    // other errors are not affected
+    cases = map[string]string {
+        "${foo"         : "unexpected end of string, \"}\" is missing",
+        "${100}"        : "too large capture group number: \"100\"",
+        "${foo|nope}"   : "unknown filter \"nope\"",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testLenientPattern(t *testing.T) {
+    c := newOperatorCorgi(t, WithLenient(regexp.MustCompile("^[A-Z_]+$")))
+
+    text := "$HOME ${USER_NAME} $set"
+    expected := "$HOME ${USER_NAME} value"
+
+    if data := parse(t, c, text); data != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 data)
+    }
+
+    if _, err := c.Parse("$HOME $foo"); err == nil {
+        t.Fatal("unexpected successful parsing")
+
+    } else if err.Error() != "unknown variable \"foo\"" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    c = newOperatorCorgi(t, WithSyntax(MustacheSyntax),
+                        WithLenient(regexp.MustCompile("^x")))
+
+    text = "{{xfoo}} {{xbar:-{{set}}}} {{set}}"
+    expected = "{{xfoo}} {{xbar:-{{set}}}} value"
+
+    if data := parse(t, c, text); data != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 data)
+    }
+}
+
+
+func TestLenient(t *testing.T) {
+    testLenientAll(t)
+    testLenientPattern(t)
+}
@@ -0,0 +1,784 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "bytes"
+    "errors"
+    "strconv"
+    "unicode/utf8"
+)
+
+
+const (
+    OPERATOR_DEFAULT     = '-'
+    OPERATOR_ASSIGN      = '='
+    OPERATOR_ERROR       = '?'
+    OPERATOR_ALTERNATIVE = '+'
+    OPERATOR_PREFIX      = '#'
+    OPERATOR_SUFFIX      = '%'
+    OPERATOR_REPLACE     = '/'
+    OPERATOR_SUBSTRING   = ':'
+)
+
+
+// scriptOperator describes the shell-style operator inside the brackets,
+// e.g. ${name:-word}. When colon is true, a null(empty) value is treated
+// as the unset one.
+// For the pattern operators(#, %, /), word is the pattern and longest marks
+// the doubled form(##, %%, //), replacement is only used by the "/", pattern
+// is the compiled word if it has no references.
+// For the substring operator, offset and length are counted in characters,
+// length is meaningful only if hasLength is true.
+type scriptOperator struct {
+    op           byte
+    colon        bool
+    word        *ComplexValue
+    longest      bool
+    pattern     *globPattern
+    replacement *ComplexValue
+    offset       int
+    length       int
+    hasLength    bool
+}
+
+
+func isOperator(ch byte) bool {
+    switch (ch) {
+
+    case OPERATOR_DEFAULT, OPERATOR_ASSIGN, OPERATOR_ERROR,
+         OPERATOR_ALTERNATIVE, OPERATOR_PREFIX, OPERATOR_SUFFIX,
+         OPERATOR_REPLACE:
+
+        return true
+    }
+
+    return false
+}
+
+
+// parseOffset parses the signed integer of the substring operator, a space
+// is needed before the negative number, e.g. ${name: -2}, since ":-" is
+// the default operator.
+func (p *parser) parseOffset(name string) (int, error) {
+    p.skipSpaces()
+
+    negative := false
+
+    if p.pos < len(p.text) && p.text[p.pos] == '-' {
+        negative = true
+        p.pos++
+    }
+
+    n, ok := p.parseNumber()
+    if ok == false {
+        return 0, fmt.Errorf("invalid substring offset for variable \"%s\"",
+                             name)
+    }
+
+    p.skipSpaces()
+
+    if negative {
+        return -n, nil
+    }
+
+    return n, nil
+}
+
+
+func (p *parser) parseSubstring(operator *scriptOperator, name string) error {
+    var err error
+
+    operator.op = OPERATOR_SUBSTRING
+    operator.colon = false
+
+    if operator.offset, err = p.parseOffset(name); err != nil {
+        return err
+    }
+
+    if p.pos < len(p.text) && p.text[p.pos] == VARIABLE_COLON &&
+       p.isFormat() == false {
+
+        // skips the colon
+        p.pos++
+
+        if operator.length, err = p.parseOffset(name); err != nil {
+            return err
+        }
+
+        operator.hasLength = true
+    }
+
+    return nil
+}
+
+
+// parsePattern parses the pattern, which is also a template, until any byte
+// in terminators, a backslash escapes the next byte, and is kept so that
+// the pattern matching treats the next byte literally.
+func (p *parser) parsePattern(terminators []string) (*ComplexValue, error) {
+    pattern := p.corgi.newComplexValue()
+
+    terminators = append(terminators, "\\")
+
+    for {
+        if err := p.parseSequence(pattern, terminators); err != nil {
+            return nil, err
+        }
+
+        if p.pos == len(p.text) || p.text[p.pos] != '\\' {
+            return pattern, nil
+        }
+
+        end := p.pos + 2
+        if end > len(p.text) {
+            end = len(p.text)
+        }
+
+        if err := pattern.append(p.text[p.pos:end], false); err != nil {
+            return nil, err
+        }
+
+        p.pos = end
+    }
+}
+
+
+func (p *parser) parseOperator(name string) (*scriptOperator, error) {
+    var operator *scriptOperator = new(scriptOperator)
+    var err       error
+
+    terminators := []string { p.syntax.RBracket, string(FILTER_PIPE) }
+
+    if p.text[p.pos] == VARIABLE_COLON {
+        operator.colon = true
+        p.pos++
+    }
+
+    if p.pos == len(p.text) {
+        return nil, p.errUnexpectedEnd()
+    }
+
+    ch := p.text[p.pos]
+
+    if operator.colon && (ch == ' ' || (ch >= '0' && ch <= '9')) {
+        if err := p.parseSubstring(operator, name); err != nil {
+            return nil, err
+        }
+
+        return operator, nil
+    }
+
+    switch (ch) {
+
+    case OPERATOR_DEFAULT, OPERATOR_ASSIGN, OPERATOR_ERROR,
+         OPERATOR_ALTERNATIVE:
+
+        break
+
+    case OPERATOR_PREFIX, OPERATOR_SUFFIX, OPERATOR_REPLACE:
+        if operator.colon == false {
+            break
+        }
+
+        fallthrough
+
+    default:
+        return nil, fmt.Errorf("unknown operator \"%s\" for variable \"%s\"",
+                               p.text[p.pos - 1:p.pos + 1], name)
+    }
+
+    operator.op = ch
+    p.pos++
+
+    switch (ch) {
+
+    case OPERATOR_PREFIX, OPERATOR_SUFFIX, OPERATOR_REPLACE:
+        if p.pos < len(p.text) && p.text[p.pos] == ch {
+            operator.longest = true
+            p.pos++
+        }
+
+        if ch == OPERATOR_REPLACE {
+            terminators = append(terminators, string(OPERATOR_REPLACE))
+        }
+
+        if operator.word, err = p.parsePattern(terminators); err != nil {
+            return nil, err
+        }
+
+        operator.pattern = staticPattern(operator.word)
+
+        if ch == OPERATOR_REPLACE {
+            operator.replacement = p.corgi.newComplexValue()
+
+            if p.pos < len(p.text) && p.text[p.pos] == OPERATOR_REPLACE {
+                p.pos++
+
+                operator.replacement, err = p.parseWord()
+                if err != nil {
+                    return nil, err
+                }
+            }
+        }
+
+    default:
+        if operator.word, err = p.parseWord(); err != nil {
+            return nil, err
+        }
+    }
+
+    return operator, nil
+}
+
+
+func (c *coder) captureValue(name string) (string, bool) {
+    n, _ := strconv.Atoi(name)
+
+    if isNamedCapture(name) {
+        index, ok := c.groupIndex(name[1:len(name) - 1])
+        if ok == false {
+            return "", false
+        }
+
+        n = index
+    }
+
+    if n >= len(c.group) {
+        return "", false
+    }
+
+    return c.group[n], true
+}
+
+
+// matchClass matches ch with the bracket expression at the beginning of
+// pattern, e.g. "[a-z]", the rest of pattern is returned. ok is false if
+// the bracket expression is not terminated.
+func matchClass(pattern string, ch rune) (matched bool, rest string, ok bool) {
+    i := 1
+    negative := false
+
+    if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+        negative = true
+        i++
+    }
+
+    for first := true; i < len(pattern); first = false {
+        if pattern[i] == ']' && first == false {
+            return matched != negative, pattern[i + 1:], true
+        }
+
+        if pattern[i] == '\\' && i + 1 < len(pattern) {
+            i++
+        }
+
+        lo, size := utf8.DecodeRuneInString(pattern[i:])
+        i += size
+        hi := lo
+
+        if i + 1 < len(pattern) && pattern[i] == '-' && pattern[i + 1] != ']' {
+            hi, size = utf8.DecodeRuneInString(pattern[i + 1:])
+            i += 1 + size
+        }
+
+        if ch >= lo && ch <= hi {
+            matched = true
+        }
+    }
+
+    return false, "", false
+}
+
+
+// globToken is a unit of the shell pattern, which matches any string if star
+// is true, any character if any is true, one of the characters of the
+// bracket expression class, or the character ch.
+type globToken struct {
+    star    bool
+    any     bool
+    class   string
+    ch      rune
+}
+
+
+// compileGlob splits the shell pattern into tokens, "*" matches any
+// string(including "/"), "?" matches any character, "[...]" matches one
+// of the enclosed characters and "\\" escapes the next character, the
+// adjacent stars are merged.
+func compileGlob(pattern string) []globToken {
+    var tokens []globToken
+
+    for pattern != "" {
+        switch (pattern[0]) {
+
+        case '*':
+            if n := len(tokens); n == 0 || tokens[n - 1].star == false {
+                tokens = append(tokens, globToken { star : true })
+            }
+
+            pattern = pattern[1:]
+            continue
+
+        case '?':
+            tokens = append(tokens, globToken { any : true })
+            pattern = pattern[1:]
+            continue
+
+        case '[':
+            if _, rest, ok := matchClass(pattern, 0); ok {
+                class := pattern[:len(pattern) - len(rest)]
+
+                tokens = append(tokens, globToken { class : class })
+                pattern = rest
+
+                continue
+            }
+
+            // treats the unterminated "[" literally
+
+        case '\\':
+            if len(pattern) > 1 {
+                pattern = pattern[1:]
+            }
+        }
+
+        ch, size := utf8.DecodeRuneInString(pattern)
+
+        tokens = append(tokens, globToken { ch : ch })
+        pattern = pattern[size:]
+    }
+
+    return tokens
+}
+
+
+// match reports whether the non-star token matches ch.
+func (token *globToken) match(ch rune) bool {
+    if token.any {
+        return true
+    }
+
+    if token.class != "" {
+        matched, _, _ := matchClass(token.class, ch)
+        return matched
+    }
+
+    return token.ch == ch
+}
+
+
+// globPattern is the compiled shell pattern, reversed is the reversed
+// tokens, which match the suffixes from the end.
+type globPattern struct {
+    tokens     []globToken
+    reversed   []globToken
+}
+
+
+func compilePattern(pattern string) *globPattern {
+    tokens := compileGlob(pattern)
+    reversed := make([]globToken, len(tokens))
+
+    for i := range tokens {
+        reversed[len(tokens) - 1 - i] = tokens[i]
+    }
+
+    return &globPattern {
+        tokens   : tokens,
+        reversed : reversed,
+    }
+}
+
+
+// staticPattern compiles the pattern word once if it has no references, or
+// nil is returned, and it's compiled every coding.
+func staticPattern(word *ComplexValue) *globPattern {
+    var pattern    string
+
+    for i := 0; i < word.size; i++ {
+        if word.code[i].kind != SCRIPT_PLAIN {
+            return nil
+        }
+
+        pattern += word.code[i].data
+    }
+
+    return compilePattern(pattern)
+}
+
+
+// globScan matches the tokens with the prefixes of s at once(or with the
+// suffixes if reverse is true, the tokens must be reversed then), by
+// tracking all the positions of tokens in states, which holds
+// 2 * (len(tokens) + 1) flags. visit is invoked with the length(in bytes)
+// of each matched prefix, from the shortest one, until it returns false.
+// The time is O(len(tokens) * len(s)).
+func globScan(tokens []globToken, s string, reverse bool, states []bool,
+              visit func(n int) bool) {
+
+    current := states[:len(tokens) + 1]
+    next := states[len(tokens) + 1:]
+
+    // a star can match the empty string
+    closure := func(states []bool) bool {
+        active := false
+
+        for i := range states {
+            if states[i] && i < len(tokens) && tokens[i].star {
+                states[i + 1] = true
+            }
+
+            active = active || states[i]
+        }
+
+        return active
+    }
+
+    for i := range current {
+        current[i] = false
+    }
+
+    current[0] = true
+    closure(current)
+
+    for n := 0; ; {
+        if current[len(tokens)] && visit(n) == false {
+            return
+        }
+
+        if n == len(s) {
+            return
+        }
+
+        var ch      rune
+        var size    int
+
+        if reverse {
+            ch, size = utf8.DecodeLastRuneInString(s[:len(s) - n])
+
+        } else {
+            ch, size = utf8.DecodeRuneInString(s[n:])
+        }
+
+        for i := range next {
+            next[i] = false
+        }
+
+        for i := 0; i < len(tokens); i++ {
+            if current[i] == false {
+                continue
+            }
+
+            if tokens[i].star {
+                next[i] = true
+
+            } else if tokens[i].match(ch) {
+                next[i + 1] = true
+            }
+        }
+
+        if closure(next) == false {
+            return
+        }
+
+        current, next = next, current
+        n += size
+    }
+}
+
+
+// globPrefix returns the length(in bytes) of the shortest or longest prefix
+// of s(or suffix if reverse is true) which matches the tokens, or -1 if
+// there is none.
+func globPrefix(tokens []globToken, s string, reverse bool, longest bool,
+                states []bool) int {
+
+    end := -1
+
+    globScan(tokens, s, reverse, states, func(n int) bool {
+        end = n
+        return longest
+    })
+
+    return end
+}
+
+
+func (glob *globPattern) states() []bool {
+    return make([]bool, 2 * (len(glob.tokens) + 1))
+}
+
+
+// stripPrefix removes the shortest or longest prefix of value which matches
+// glob, in O(len(pattern) * len(value)) time.
+func stripPrefix(value string, glob *globPattern, longest bool) string {
+    end := globPrefix(glob.tokens, value, false, longest, glob.states())
+    if end < 0 {
+        return value
+    }
+
+    return value[end:]
+}
+
+
+// stripSuffix matches the reversed pattern with value from its end, in
+// O(len(pattern) * len(value)) time.
+func stripSuffix(value string, glob *globPattern, longest bool) string {
+    n := globPrefix(glob.reversed, value, true, longest, glob.states())
+    if n < 0 {
+        return value
+    }
+
+    return value[:len(value) - n]
+}
+
+
+// replacePattern replaces the longest match of glob with replacement, all
+// matches will be replaced if all is true. The longest match is looked for
+// from each position, so the time is O(len(pattern) * len(value)^2) in the
+// worst case, e.g. "a*b" with a long value of "a", but O(len(pattern) *
+// len(value)) for the pattern which starts with a star.
+func replacePattern(value string, glob *globPattern, replacement string,
+                    all bool) string {
+
+    var buffer    bytes.Buffer
+
+    if len(glob.tokens) == 0 {
+        return value
+    }
+
+    states := glob.states()
+    last := 0
+
+    for i := 0; i < len(value); {
+        // the longest non-empty match anchored at i
+        n := globPrefix(glob.tokens, value[i:], false, true, states)
+
+        if n > 0 {
+            buffer.WriteString(value[last:i])
+            buffer.WriteString(replacement)
+
+            i += n
+            last = i
+
+            if all == false {
+                break
+            }
+
+            continue
+        }
+
+        // the leading star would have matched from i the later match
+        if glob.tokens[0].star {
+            break
+        }
+
+        _, size := utf8.DecodeRuneInString(value[i:])
+        i += size
+    }
+
+    if last == 0 {
+        return value
+    }
+
+    buffer.WriteString(value[last:])
+
+    return buffer.String()
+}
+
+
+// boundaries returns the byte offsets of all characters in s, plus len(s).
+func boundaries(s string) []int {
+    var offsets []int = make([]int, 0, len(s) + 1)
+
+    for i := range s {
+        offsets = append(offsets, i)
+    }
+
+    return append(offsets, len(s))
+}
+
+
+// substring extracts the characters of value like the shell does, the
+// negative offset counts from the end of value, and so does the negative
+// length.
+func substring(value string, operator *scriptOperator) (string, error) {
+    offsets := boundaries(value)
+    n := len(offsets) - 1
+
+    start := operator.offset
+    if start < 0 {
+        start += n
+    }
+
+    if start < 0 || start > n {
+        return "", nil
+    }
+
+    end := n
+
+    if operator.hasLength {
+        if operator.length < 0 {
+            end = n + operator.length
+
+            if end < start {
+                return "", fmt.Errorf("substring expression < 0: %d",
+                                      operator.length)
+            }
+
+        } else if start + operator.length < n {
+            end = start + operator.length
+        }
+    }
+
+    return value[offsets[start]:offsets[end]], nil
+}
+
+
+// captureGet gets the capture group, which name is the group number.
+func (c *coder) captureGet(ref *scriptReference,
+                           name string) (string, error) {
+
+    if c.group == nil {
+        return "", errors.New("empty capture group")
+    }
+
+    value, found := c.captureOf(ref, name)
+    if found == false {
+        if isNamedCapture(name) {
+            return "", fmt.Errorf("unknown capture group \"%s\"", name)
+        }
+
+        return "", errors.New("too large capture number")
+    }
+
+    return value, nil
+}
+
+
+// referenceValue gets the value of a variable or a capture group, and
+// whether the value is found.
+func (c *coder) referenceValue(ref *scriptReference,
+                                name string) (string, bool, error) {
+
+    if ref.isCapture(name) {
+        value, found := c.captureOf(ref, name)
+        return value, found, nil
+    }
+
+    result, err := c.valueOf(ref, name)
+    if err != nil {
+        return "", false, err
+    }
+
+    return result.Value, !result.NotFound, nil
+}
+
+
+// operatorGet applies the operator to the variable name, which is the
+// evaluated name for the indirect reference.
+func (c *coder) operatorGet(code *scriptCode, name string) (string, error) {
+    operator := code.operator
+
+    value, found, err := c.referenceValue(code.ref, name)
+    if err != nil {
+        return "", err
+    }
+
+    // the variable is set and not null(if colon is specified)
+    set := found && (operator.colon == false || value != "")
+
+    switch (operator.op) {
+
+    case OPERATOR_DEFAULT:
+        if set {
+            return value, nil
+        }
+
+        return c.codeString(operator.word)
+
+    case OPERATOR_ASSIGN:
+        if set {
+            return value, nil
+        }
+
+        word, err := c.codeString(operator.word)
+        if err != nil {
+            return "", err
+        }
+
+        if err := c.variableAssign(name, word); err != nil {
+            return "", err
+        }
+
+        return word, nil
+
+    case OPERATOR_ERROR:
+        if set {
+            return value, nil
+        }
+
+        message, err := c.codeString(operator.word)
+        if err != nil {
+            return "", err
+        }
+
+        if message == "" {
+            message = "parameter null or not set"
+        }
+
+        return "", fmt.Errorf("%s: %s", name, message)
+
+    case OPERATOR_ALTERNATIVE:
+        if set {
+            return c.codeString(operator.word)
+        }
+
+        return "", nil
+    }
+
+    // the rest operators transform the value, which must be found
+
+    if found == false {
+        if code.ref.isCapture(name) {
+            return c.captureGet(code.ref, name)
+        }
+
+        return "", fmt.Errorf("vlaue of variable \"%s\" not found", name)
+    }
+
+    if operator.op == OPERATOR_SUBSTRING {
+        return substring(value, operator)
+    }
+
+    glob := operator.pattern
+
+    if glob == nil {
+        pattern, err := c.codeString(operator.word)
+        if err != nil {
+            return "", err
+        }
+
+        glob = compilePattern(pattern)
+    }
+
+    switch (operator.op) {
+
+    case OPERATOR_PREFIX:
+        return stripPrefix(value, glob, operator.longest), nil
+
+    case OPERATOR_SUFFIX:
+        return stripSuffix(value, glob, operator.longest), nil
+
+    default:
+        replacement, err := c.codeString(operator.replacement)
+        if err != nil {
+            return "", err
+        }
+
+        return replacePattern(value, glob, replacement,
+                              operator.longest), nil
+    }
+}
@@ -0,0 +1,453 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "strings"
+    "testing"
+)
+
+
+var assigned string
+
+// long is the value of $long, which makes the backtracking matcher slow.
+var long string = strings.Repeat("a", 1024)
+
+
+func variableOperatorGet(value *VariableValue, _ interface{}, name string) error {
+    value.Cacheable = false
+
+    if name == "set" {
+        value.Value = "value"
+        value.NotFound = false
+        return nil
+    }
+
+    if name == "null" {
+        value.Value = ""
+        value.NotFound = false
+        return nil
+    }
+
+    if name == "long" {
+        value.Value = long
+        value.NotFound = false
+        return nil
+    }
+
+    if name == "path" {
+        value.Value = "/usr/local/lib/libcorgi.so.1"
+        value.NotFound = false
+        return nil
+    }
+
+    value.NotFound = true
+
+    return nil
+}
+
+
+func variableOperatorSet(value *VariableValue, _ interface{}, _ string) error {
+    assigned = value.Value
+    return nil
+}
+
+
+var operatorVariables []*Variable = []*Variable {
+    &Variable {
+        Name  : "set",
+        Get   : variableOperatorGet,
+    },
+
+    &Variable {
+        Name  : "null",
+        Get   : variableOperatorGet,
+    },
+
+    &Variable {
+        Name  : "unset",
+        Get   : variableOperatorGet,
+        Set   : variableOperatorSet,
+    },
+
+    &Variable {
+        Name  : "path",
+        Get   : variableOperatorGet,
+    },
+
+    &Variable {
+        Name  : "long",
+        Get   : variableOperatorGet,
+    },
+}
+
+
+func newOperatorCorgi(t *testing.T, options ...Option) *Corgi {
+    c, err := New(options...)
+    if err != nil {
+        t.Fatalf("failed to create corgi instance: %s", err.Error())
+    }
+
+    if err := c.RegisterNewVariables(operatorVariables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testOperatorDefault(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${set:-word}"                 : "value",
+        "${null:-word}"                : "word",
+        "${unset:-word}"               : "word",
+        "${set-word}"                  : "value",
+        "${null-word}"                 : "",
+        "${unset-word}"                : "word",
+        "${unset:-}"                   : "",
+        "${unset:-$set and ${null}}"   : "value and ",
+        "${unset:-${null:-$$nested}}"  : "$nested",
+        "${env_corgi_xxxxx:-none}"     : "none",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+}
+
+
+func testOperatorAlternative(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${set:+word}"   : "word",
+        "${null:+word}"  : "",
+        "${unset:+word}" : "",
+        "${set+word}"    : "word",
+        "${null+word}"   : "word",
+        "${unset+word}"  : "",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+}
+
+
+func testOperatorAssign(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    data := parse(t, c, "${unset:=assigned}, $unset")
+    if data != "assigned, assigned" {
+        t.Fatalf("incorrect value, expected \"assigned, assigned\" but seen \"%s\"",
+                 data)
+    }
+
+    if assigned != "assigned" {
+        t.Fatalf("set handler is not invoked, seen \"%s\"", assigned)
+    }
+
+    // the assigned value is kept in the coding only
+    data = parse(t, c, "${unset:-none}")
+    if data != "none" {
+        t.Fatalf("incorrect value, expected \"none\" but seen \"%s\"", data)
+    }
+
+    data = parse(t, c, "${set=other}")
+    if data != "value" {
+        t.Fatalf("incorrect value, expected \"value\" but seen \"%s\"", data)
+    }
+
+    errorReason := "cannot assign to capture group \"1\""
+
+    if _, err := c.Parse("${1:=word}"); err == nil {
+        t.Fatal("unexpected successful parsing")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testOperatorError(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${null:?is empty}" : "null: is empty",
+        "${unset?}"         : "unset: parameter null or not set",
+    }
+
+    for text, errorReason := range cases {
+        cv, err := c.Parse(text)
+        if err != nil {
+            t.Fatalf("failed to parse \"%s\": %s", text, err.Error())
+        }
+
+        if _, err := c.Code(cv); err == nil {
+            t.Fatal("unexpected successful coding")
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    data := parse(t, c, "${set:?is empty}${null?is unset}")
+    if data != "value" {
+        t.Fatalf("incorrect value, expected \"value\" but seen \"%s\"", data)
+    }
+}
+
+
+func testOperatorCapture(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    c.Group = []string { "all", "first", "" }
+
+    data := parse(t, c, "${1:-none} ${2:-none} ${3:-none} ${2+null}")
+    if data != "first none none null" {
+        t.Fatalf("incorrect value, expected \"first none none null\" but seen \"%s\"",
+                 data)
+    }
+}
+
+
+func testOperatorPattern(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${path#*/}"                 : "usr/local/lib/libcorgi.so.1",
+        "${path##*/}"                : "libcorgi.so.1",
+        "${path%.*}"                 : "/usr/local/lib/libcorgi.so",
+        "${path%%.*}"                : "/usr/local/lib/libcorgi",
+        "${path%/*}"                 : "/usr/local/lib",
+        "${path#/usr}"               : "/local/lib/libcorgi.so.1",
+        "${path#xxx}"                : "/usr/local/lib/libcorgi.so.1",
+        "${path##*[0-9]}"            : "",
+        "${path#*l*b}"               : "/libcorgi.so.1",
+        "${path##*l*b}"              : "corgi.so.1",
+        "${path%l*b*}"               : "/usr/local/lib/",
+        "${path%%l*b*}"              : "/usr/",
+        "${path/lib/LIB}"            : "/usr/local/LIB/libcorgi.so.1",
+        "${path//lib/LIB}"           : "/usr/local/LIB/LIBcorgi.so.1",
+        "${path//\\//:}"             : ":usr:local:lib:libcorgi.so.1",
+        "${path/*lib/x}"             : "xcorgi.so.1",
+        "${path/?usr/}"              : "/local/lib/libcorgi.so.1",
+        "${path/l?b/x}"              : "/usr/local/x/libcorgi.so.1",
+        "${path//[.]/-}"             : "/usr/local/lib/libcorgi-so-1",
+        "${path##*/|upper}"          : "LIBCORGI.SO.1",
+        "${set/$set/$null}"          : "",
+        "${set/a/A|upper:>6}"        : " VALUE",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    // the patterns match the characters rather than the bytes
+    c.Group = []string { "", "世界你好世界" }
+
+    cases = map[string]string {
+        "${1#*界}"       : "你好世界",
+        "${1##*界}"      : "",
+        "${1%界*}"       : "世界你好世",
+        "${1%%界*}"      : "世",
+        "${1%?}"         : "世界你好世",
+        "${1//界/x}"     : "世x你好世x",
+        "${1/[你好]/x}"  : "世界x好世界",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    // the pattern without references is compiled once
+    cv, err := c.Parse("${path#*/} ${path#$set}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if cv.code[0].operator.pattern == nil || cv.code[2].operator.pattern != nil {
+        t.Fatal("unexpected compiled patterns")
+    }
+
+    cv, err = c.Parse("${unset#x}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    errorReason := "vlaue of variable \"unset\" not found"
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testOperatorSubstring(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    c.Group = []string { "", "世界你好" }
+
+    cases := map[string]string {
+        "${set:1}"         : "alue",
+        "${set:1:2}"       : "al",
+        "${set:0:3}"       : "val",
+        "${set: -2}"       : "ue",
+        "${set: -3:2}"     : "lu",
+        "${set:1: -1}"     : "alu",
+        "${set:10}"        : "",
+        "${set:2:10}"      : "lue",
+        "${1:1:2}"         : "界你",
+        "${set:1:2:>4}"    : "  al",
+        "${set:0|upper}"   : "VALUE",
+        "${set:02}"        : "value",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    cv, err := c.Parse("${set:3: -3}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "substring expression < 0: -3" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+// testOperatorPatternLong matches the patterns with several stars against a
+// long value, which takes polynomial time rather than exponential time.
+func testOperatorPatternLong(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${long##*a*a*a*a*a*b}"      : long,
+        "${long%%*a*a*a*a*a*b}"      : long,
+        "${long#*a*a*a*a*a*a}"       : long[6:],
+        "${long%a*a*a*a*a*a}"        : long[6:],
+        "${long##a*a*a*a*a*a}"       : "",
+        "${long//*a*a*a*a*a*b/x}"    : long,
+        "${long/a*a*a*a*a*a/x}"      : "x",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected %d characters but "+
+                     "seen %d", text, len(expected), len(data))
+        }
+    }
+
+    if globMatches("*a*a*a*a*a*b", long) {
+        t.Fatal("unexpected match of long value")
+    }
+
+    // no match from any position
+    if data := parse(t, c, "${long//a*b/x}"); data != long {
+        t.Fatalf("incorrect value of \"${long//a*b/x}\", expected %d "+
+                 "characters but seen %d", len(long), len(data))
+    }
+}
+
+
+// globMatches reports whether the whole s matches the shell pattern.
+func globMatches(pattern string, s string) bool {
+    glob := compilePattern(pattern)
+    return globPrefix(glob.tokens, s, false, true, glob.states()) == len(s)
+}
+
+
+func testOperatorGlob(t *testing.T) {
+    cases := map[string]bool {
+        "*"          : true,
+        "a*"         : true,
+        "*c"         : true,
+        "a?c"        : true,
+        "a??c"       : false,
+        "[a-c]*"     : true,
+        "[!a]*"      : false,
+        "[^b]bc"     : true,
+        "a\\bc"      : true,
+        "a\\*"       : false,
+        "[abc"       : false,
+        "abc"        : true,
+        "ab"         : false,
+    }
+
+    for pattern, expected := range cases {
+        if globMatches(pattern, "abc") != expected {
+            t.Fatalf("incorrect matching of pattern \"%s\", expected %v",
+                     pattern, expected)
+        }
+    }
+
+    if globMatches("[[]*", "[abc") == false {
+        t.Fatal("failed to match \"[abc\" with \"[[]*\"")
+    }
+}
+
+
+func testOperatorParseFailed(t *testing.T) {
+    c := newOperatorCorgi(t)
+
+    cases := map[string]string {
+        "${set:-word"       : "unexpected end of string, \"}\" is missing",
+        "${set:"            : "unexpected end of string, \"}\" is missing",
+        "${set:*word}"      : "unknown operator \":*\" for variable \"set\"",
+        "${set:-$xxxxx}"    : "unknown variable \"xxxxx\"",
+        "${xxxxx:-word}"    : "unknown variable \"xxxxx\"",
+        "${:-word}"         : "\"}\" for variable \"\" is missing",
+        "${set:#word}"      : "unknown operator \":#\" for variable \"set\"",
+        "${set:1:x}"        : "invalid substring offset for variable \"set\"",
+        "${set: x}"         : "invalid substring offset for variable \"set\"",
+        "${set/a/b"         : "unexpected end of string, \"}\" is missing",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func TestOperator(t *testing.T) {
+    testOperatorDefault(t)
+    testOperatorAlternative(t)
+    testOperatorAssign(t)
+    testOperatorError(t)
+    testOperatorCapture(t)
+    testOperatorPattern(t)
+    testOperatorPatternLong(t)
+    testOperatorSubstring(t)
+    testOperatorGlob(t)
+    testOperatorParseFailed(t)
+}
@@ -3,10 +3,12 @@
 package corgi
 
 import (
+    "io"
     "fmt"
-    "bytes"
     "errors"
+    "sync"
     "strconv"
+    "strings"
 )
 
 
@@ -14,28 +16,67 @@ const (
     VARIABLE_PREFACE  = '$'
     VARIABLE_LBRACKET = '{'
     VARIABLE_RBRACKET = '}'
-
-    PARSE_PLAIN = iota
-    PARSE_VARIABLE_PREFACE
-    PARSE_VARIABLE
+    VARIABLE_COLON    = ':'
 
     SCRIPT_PLAIN = iota
     SCRIPT_VARIABLE
     SCRIPT_CAPTURE
+    SCRIPT_OPERATOR
+    SCRIPT_CONDITION
+    SCRIPT_INDIRECT
+    SCRIPT_FUNCTION
+    SCRIPT_ARITHMETIC
 )
 
 
 type scriptCode struct {
-    kind  uint
-    data  string
+    kind        uint
+    data        string
+    ref        *scriptReference
+    name       *ComplexValue
+    operator   *scriptOperator
+    filters     []FilterFunc
+    format     *scriptFormat
+    escape      Escaper
+    condition  *scriptCondition
+    function   *scriptFunction
+    arithmetic *scriptArithmetic
 }
 
 
 // ComplexValue is used to describe the result of Corgi.Parse.
+// For the result of Corgi.Optimize, source is the unfolded one, which is
+// coded instead once generation is outdated.
 type ComplexValue struct {
-    code  []scriptCode
-    size    int
-    corgi  *Corgi
+    code        []scriptCode
+    size          int
+    corgi        *Corgi
+    source       *ComplexValue
+    generation    uint64
+}
+
+
+type parser struct {
+    corgi   *Corgi
+    syntax  *Syntax
+    text     string
+    pos      int
+    depth    int
+    open     span
+
+    // an unknown variable is kept as the plain text in lenient mode
+    kept     bool
+
+    // collects the errors rather than stops at the first one
+    collect  bool
+    errors   ParseErrors
+}
+
+
+// span is the range [from, to) of the template.
+type span struct {
+    from  int
+    to    int
 }
 
 
@@ -60,6 +101,82 @@ func isValidVariableCharacter(ch rune) bool {
 }
 
 
+func isValidName(name string) bool {
+    if name == "" {
+        return false
+    }
+
+    for _, ch := range name {
+        if isValidVariableCharacter(ch) == false {
+            return false
+        }
+    }
+
+    return true
+}
+
+
+func (corgi *Corgi) newComplexValue() *ComplexValue {
+    var cv *ComplexValue = new(ComplexValue)
+
+    cv.corgi = corgi
+
+    return cv
+}
+
+
+// reference checks whether name can be referenced by a template, the
+// returned value is the script kind of this reference. In late binding mode,
+// the unknown names are checked by Corgi.Code.
+func (cv *ComplexValue) reference(name string) (uint, error) {
+    if isNamedCapture(name) {
+        return SCRIPT_CAPTURE, nil
+    }
+
+    if n, err := strconv.Atoi(name); err == nil {
+        // we treat numeric name as the regular expression capture group number
+
+        if n > 99 {
+            return 0, newParseError(ErrBadCapture,
+                       fmt.Errorf("too large capture group number: \"%d\"", n))
+        }
+
+        return SCRIPT_CAPTURE, nil
+    }
+
+    root, path := splitPath(name)
+
+    if path != nil {
+        if _, err := strconv.Atoi(root); err == nil {
+            return 0, newParseError(ErrBadCapture,
+                       fmt.Errorf("capture group \"%s\" has no paths", root))
+        }
+    }
+
+    variable, _, _ := cv.corgi.lookupVariable(root)
+
+    if variable == nil {
+        // the name of a capture group bound before
+        if path == nil && cv.corgi.isCaptureGroup(name) {
+            return SCRIPT_CAPTURE, nil
+        }
+
+        if cv.corgi.lateBinding {
+            return SCRIPT_VARIABLE, nil
+        }
+
+        return 0, newParseError(ErrUnknownVariable,
+                                fmt.Errorf("unknown variable \"%s\"", root))
+    }
+
+    if path != nil && variable.GetPath == nil {
+        return 0, fmt.Errorf("variable \"%s\" has no paths", root)
+    }
+
+    return SCRIPT_VARIABLE, nil
+}
+
+
 func (cv *ComplexValue) append(name string, variable bool) error {
     if variable == false {
         cv.code = append(cv.code, scriptCode{
@@ -74,142 +191,454 @@ func (cv *ComplexValue) append(name string, variable bool) error {
 
     // this is a variable
 
-    if n, err := strconv.Atoi(name); err == nil {
-        // we treat numeric name as the regular expression capture group number
+    kind, err := cv.reference(name)
+    if err != nil {
+        return err
+    }
 
-        if n > 99 {
-            return fmt.Errorf("too large capture group number: \"%d\"", n)
-        }
+    if kind == SCRIPT_CAPTURE {
+        name = captureName(name)
+    }
 
-        cv.code = append(cv.code, scriptCode {
-            kind: SCRIPT_CAPTURE,
-            data: name,
-        })
+    cv.code = append(cv.code, scriptCode {
+        kind : kind,
+        data : name,
+        ref  : cv.corgi.compileReference(kind, name),
+    })
 
+    cv.size++
+
+    return nil
+}
+
+
+// appendReference appends the bracketed variable reference, which may carry
+// the operator and the filters.
+func (cv *ComplexValue) appendReference(code scriptCode) error {
+    if code.name != nil {
+        // the indirect reference, which is checked when coding
+
+        code.kind = SCRIPT_INDIRECT
+        if code.operator != nil {
+            code.kind = SCRIPT_OPERATOR
+        }
+
+        cv.code = append(cv.code, code)
         cv.size++
 
         return nil
     }
 
-    if _, ok := cv.corgi.variables[name]; ok == false {
+    kind, err := cv.reference(code.data)
+    if err != nil {
+        return err
+    }
+
+    code.ref = cv.corgi.compileReference(kind, code.data)
+
+    isCapture := kind == SCRIPT_CAPTURE
 
-        // is the unknown variable?
-        if variable := cv.corgi.validUnknownVariable(name); variable == nil {
-            return fmt.Errorf("unknown variable \"%s\"", name)
+    if code.operator != nil {
+        if kind == SCRIPT_CAPTURE && code.operator.op == OPERATOR_ASSIGN {
+            return newParseError(ErrBadCapture,
+                       fmt.Errorf("cannot assign to capture group \"%s\"",
+                                  code.data))
         }
+
+        if code.operator.op == OPERATOR_ASSIGN && isValidName(code.data) == false {
+            return fmt.Errorf("cannot assign to path \"%s\"", code.data)
+        }
+
+        kind = SCRIPT_OPERATOR
     }
 
-    cv.code = append(cv.code, scriptCode {
-        kind : SCRIPT_VARIABLE,
-        data : name,
-    })
+    if isCapture {
+        code.data = captureName(code.data)
+    }
+
+    code.kind = kind
 
+    cv.code = append(cv.code, code)
     cv.size++
 
     return nil
 }
 
 
-// Parse parses the textual data to the intermediate representation,
-// i.e. the instance of type ComplexValue.
-// In case of failure, a corresponding error object will be yielded.
-func (corgi *Corgi) Parse(text string) (*ComplexValue, error) {
-    state   := PARSE_PLAIN
-    from    := 0
-    bracket := false
+func (p *parser) parseName() string {
+    from := p.pos
 
-    var cv *ComplexValue = new(ComplexValue)
+    for p.pos < len(p.text) {
+        if isValidVariableCharacter(rune(p.text[p.pos])) == false {
+            break
+        }
 
-    cv.corgi = corgi
+        p.pos++
+    }
 
-    for i, ch := range text {
+    return p.text[from:p.pos]
+}
 
-        switch (state) {
 
-        case PARSE_PLAIN:
+// parseSequence parses plain text and variable references until the end of
+// text or any of terminators is met, the terminator is not consumed.
+func (p *parser) parseSequence(cv *ComplexValue, terminators []string) error {
+    from := p.pos
+    opener := p.syntax.opener()
 
-            if ch != VARIABLE_PREFACE {
-                continue
-            }
+    for p.pos < len(p.text) {
+        if p.terminated(terminators) {
+            break
+        }
 
-            state = PARSE_VARIABLE_PREFACE
+        if p.atOpener() == false {
+            p.pos++
+            continue
+        }
 
-            if err := cv.append(text[from:i], false); err != nil {
-                return nil, err
+        if p.pos > from {
+            if err := cv.append(p.text[from:p.pos], false); err != nil {
+                return err
             }
+        }
 
-        case PARSE_VARIABLE_PREFACE:
+        // $$
+        if strings.HasPrefix(p.text[p.pos + len(opener):], opener) {
+            from = p.pos + len(opener)
+            p.pos += 2 * len(opener)
+            continue
+        }
 
-            if ch == VARIABLE_LBRACKET {
-                bracket = true
-                from = i + 1
+        start, size := p.pos, len(cv.code)
 
-            } else {
-                from = i
+        if err := p.parseVariable(cv); err != nil {
+            if p.collect == false || p.depth > 0 {
+                return err
+            }
 
-                // $$
-                if ch == VARIABLE_PREFACE {
-                    state = PARSE_PLAIN
-                    continue
-                }
+            if err := p.recover(cv, err, start, size); err != nil {
+                return err
             }
+        }
+
+        from = p.pos
+    }
 
-            state = PARSE_VARIABLE
+    if p.pos > from {
+        if err := cv.append(p.text[from:p.pos], false); err != nil {
+            return err
+        }
+    }
 
-        case PARSE_VARIABLE:
+    return nil
+}
 
-            if bracket == true && ch == VARIABLE_RBRACKET {
-                state = PARSE_PLAIN
-                bracket = false
 
-                if err := cv.append(text[from:i], true); err != nil {
-                    return nil, err
-                }
+func (p *parser) terminated(terminators []string) bool {
+    for _, terminator := range terminators {
+        if p.hasPrefix(terminator) {
+            return true
+        }
+    }
 
-                from = i + 1
+    return false
+}
 
-                continue
-            }
 
-            if isValidVariableCharacter(ch) {
-                continue
-            }
+// parseVariable parses a variable reference, the nesting depth is limited so
+// that the malicious templates cannot exhaust the stack.
+func (p *parser) parseVariable(cv *ComplexValue) error {
+    if p.depth == VARIABLE_MAX_DEPTH {
+        return p.fail(errors.New("too deep nested variables"), p.pos, p.pos)
+    }
 
-            if bracket == true {
-                return nil, fmt.Errorf("\"}\" for variable \"%s\" is missing",
-                                       text[from:i])
-            }
+    p.depth++
+    err := p.parseReference(cv)
+    p.depth--
+
+    return err
+}
+
+
+func (p *parser) parseReference(cv *ComplexValue) error {
+    from := p.pos
+
+    // skips the preface(or the left bracket if the preface is empty)
+    p.pos += len(p.syntax.opener())
+
+    // whether the left bracket is consumed
+    opened := p.syntax.bare() == false
+
+    if opened == false && p.hasPrefix(p.syntax.LBracket) {
+        p.pos += len(p.syntax.LBracket)
+        return p.parseEnclosed(cv, from)
+    }
+
+    if p.hasPrefix(ARITHMETIC_OPEN) {
+        return p.parseArithmetic(cv, opened)
+    }
+
+    if p.pos < len(p.text) && p.text[p.pos] == CONDITION_MARK {
+        return p.parseCondition(cv, opened)
+    }
+
+    if opened {
+        return p.parseEnclosed(cv, from)
+    }
+
+    name := p.parseName()
+    if name == "" {
+        return p.fail(newParseError(ErrInvalidName,
+                                    errors.New("invalid variable name")),
+                      from, p.pos)
+    }
+
+    if p.pos < len(p.text) && p.text[p.pos] == FILTER_LPAREN {
+        if function := p.corgi.lookupFunction(name); function != nil {
+            return p.parseFunction(cv, function)
+        }
+    }
+
+    if err := cv.append(name, true); err != nil {
+        return p.unknownVariable(cv, err, from, p.pos - len(name), name)
+    }
+
+    return nil
+}
+
+
+// parseEnclosed parses the bracketed variable, whose left bracket, which
+// starts at from, is consumed, the bracket is remembered so that it can be
+// reported if it is not closed.
+func (p *parser) parseEnclosed(cv *ComplexValue, from int) error {
+    open := p.open
+    p.open = span { from, p.pos }
+
+    err := p.parseBracket(cv)
+
+    p.open = open
+
+    return err
+}
+
+
+// atIndirect reports whether a nested variable reference follows, which
+// makes the name of the enclosing variable indirect.
+func (p *parser) atIndirect() bool {
+    if p.atRBracket() || p.atOpener() == false {
+        return false
+    }
+
+    next := p.pos + len(p.syntax.opener())
+
+    if next == len(p.text) {
+        return false
+    }
+
+    if p.syntax.bare() == false || strings.HasPrefix(p.text[next:],
+                                                     p.syntax.LBracket) {
+        return true
+    }
+
+    return isValidVariableCharacter(rune(p.text[next]))
+}
+
+
+// parseBracket parses the bracketed variable, the left bracket is consumed.
+func (p *parser) parseBracket(cv *ComplexValue) error {
+    var code     scriptCode
+    var unknown  bool
+    var err      error
+
+    from := p.pos
+
+    if p.atCaptureName() {
+        if code.data, err = p.parseCaptureName(); err != nil {
+            return err
+        }
 
-            if err := cv.append(text[from:i], true); err != nil {
-                return nil, err
+    } else {
+        code.data = p.parsePath()
+
+        // the literal part of indirect name may be like "req.${field}"
+        mark := p.pos
+        p.parsePathPart()
+
+        if p.atIndirect() {
+            kept := p.kept
+            p.kept = false
+
+            if code.name, err = p.parseIndirectName(from); err != nil {
+                return err
             }
 
-            if ch == VARIABLE_PREFACE {
-                state = PARSE_VARIABLE_PREFACE
+            // an unknown variable is kept in the name
+            unknown = p.kept
+            p.kept = p.kept || kept
+
+            code.data = p.text[from:p.pos]
+
+        } else {
+            p.pos = mark
+        }
+    }
+
+    if p.pos == len(p.text) {
+        return p.errUnexpectedEnd()
+    }
+
+    if p.atRBracket() && code.name == nil {
+        p.pos += len(p.syntax.RBracket)
+
+        if code.data == "" {
+            return p.fail(newParseError(ErrInvalidName,
+                                        errors.New("invalid variable name")),
+                          p.open.from, p.pos)
+        }
+
+        if err := cv.append(code.data, true); err != nil {
+            return p.unknownVariable(cv, err, p.open.from, from, code.data)
+        }
+
+        return nil
+    }
+
+    if code.data == "" {
+        return p.errMissingRBracket(code.data)
+    }
+
+    ch := p.text[p.pos]
 
-            } else {
-                state = PARSE_PLAIN
+    if code.name == nil && ch == FILTER_LPAREN {
+        if function := p.corgi.lookupFunction(code.data); function != nil {
+            if err := p.parseFunction(cv, function); err != nil {
+                return err
             }
 
-            from = i
+            return p.closeBracket(code.data)
         }
     }
 
-    if state == PARSE_VARIABLE_PREFACE {
-        return nil, errors.New("invalid variable name")
+    if p.isFormat() == false && (ch == VARIABLE_COLON || isOperator(ch)) {
+        code.operator, err = p.parseOperator(code.data)
+        if err != nil {
+            return err
+        }
     }
 
-    if bracket == true {
-        return nil, errors.New("unexpected end of string, \"}\" is missing")
+    if p.pos < len(p.text) && p.text[p.pos] == FILTER_PIPE {
+        code.filters, code.escape, err = p.parseFilters()
+        if err != nil {
+            return err
+        }
     }
 
-    if from < len(text) {
-        err := cv.append(text[from:], state == PARSE_VARIABLE)
+    if p.pos < len(p.text) && p.text[p.pos] == VARIABLE_COLON {
+        code.format, err = p.parseFormat(code.data)
         if err != nil {
-            return nil, err
+            return err
         }
     }
 
+    if err := p.closeBracket(code.data); err != nil {
+        return err
+    }
+
+    if unknown {
+        return p.keepReference(cv, p.open.from)
+    }
+
+    if err := cv.appendReference(code); err != nil {
+        return p.unknownVariable(cv, err, p.open.from, from, code.data)
+    }
+
+    return nil
+}
+
+
+// closeBracket consumes the right bracket of variable name.
+func (p *parser) closeBracket(name string) error {
+    if p.pos == len(p.text) {
+        return p.errUnexpectedEnd()
+    }
+
+    if p.atRBracket() == false {
+        return p.errMissingRBracket(name)
+    }
+
+    p.pos += len(p.syntax.RBracket)
+
+    return nil
+}
+
+
+// parseWord parses the word of an operator, which is a template itself and
+// lasts until the right bracket of the enclosing variable or the filter
+// pipe, the terminator is not consumed.
+func (p *parser) parseWord() (*ComplexValue, error) {
+    word := p.corgi.newComplexValue()
+
+    terminators := []string { p.syntax.RBracket, string(FILTER_PIPE) }
+
+    if err := p.parseSequence(word, terminators); err != nil {
+        return nil, err
+    }
+
+    return word, nil
+}
+
+
+// Parse parses the textual data to the intermediate representation,
+// i.e. the instance of type ComplexValue.
+// In case of failure, a corresponding error object(*ParseError) will be
+// yielded.
+func (corgi *Corgi) Parse(text string) (*ComplexValue, error) {
+    var p *parser = &parser {
+        corgi  : corgi,
+        syntax : &corgi.syntax,
+        text   : text,
+    }
+
+    cv := corgi.newComplexValue()
+
+    if err := p.parseSequence(cv, nil); err != nil {
+        e := p.fail(err, p.pos, p.pos).(*ParseError)
+        p.locate(e)
+
+        return nil, e
+    }
+
+    return cv, nil
+}
+
+
+// ParseAll is like Parse, but it keeps parsing after an error, so that all
+// the errors are reported as ParseErrors, the bad references are kept as the
+// plain text in the yielded ComplexValue, which is the best-effort result.
+// If the parsing can't be recovered, the located error is appended to the
+// collected ones, and the ComplexValue will be nil.
+// In case of success, the error object will be nil.
+func (corgi *Corgi) ParseAll(text string) (*ComplexValue, error) {
+    var p *parser = &parser {
+        corgi   : corgi,
+        syntax  : &corgi.syntax,
+        text    : text,
+        collect : true,
+    }
+
+    cv := corgi.newComplexValue()
+
+    if err := p.parseSequence(cv, nil); err != nil {
+        e := p.fail(err, p.pos, p.pos).(*ParseError)
+        p.locate(e)
+
+        return nil, append(p.errors, e)
+    }
+
+    if len(p.errors) > 0 {
+        return cv, p.errors
+    }
+
     return cv, nil
 }
 
@@ -219,7 +648,77 @@ func (corgi *Corgi) Parse(text string) (*ComplexValue, error) {
 // In case of failure, an empty string and a corresponding error object
 // will be yielded.
 func (corgi *Corgi) Code(cv *ComplexValue) (string, error) {
-    var buffer    bytes.Buffer
+    c := corgi.newCoder(corgi.Context, corgi.Group)
+    defer c.release()
+
+    return c.codeString(cv)
+}
+
+
+// CodeTo is like Code, but it writes the result to w directly rather than
+// building a string, the count of the written bytes is yielded.
+// In case of failure, the part before the failed segment may have been
+// written to w, the short write is reported as an error as well.
+func (corgi *Corgi) CodeTo(w io.Writer, cv *ComplexValue) (int64, error) {
+    c := corgi.newCoder(corgi.Context, corgi.Group)
+    defer c.release()
+
+    return c.codeTo(w, cv)
+}
+
+
+// AppendCode is like Code, but it appends the result to dst and returns the
+// extended buffer, like strconv.AppendInt, no allocation is made if dst has
+// enough capacity and the variables values are cached.
+// In case of failure, dst and a corresponding error object will be yielded.
+func (corgi *Corgi) AppendCode(dst []byte, cv *ComplexValue) ([]byte, error) {
+    c := corgi.newCoder(corgi.Context, corgi.Group)
+    dst, err := c.appendCode(dst, cv)
+    c.release()
+
+    return dst, err
+}
+
+
+func (c *coder) codeTo(w io.Writer, cv *ComplexValue) (int64, error) {
+    var writer *codeWriter = &codeWriter { w : w }
+
+    err := c.code(writer, cv)
+
+    return writer.n, err
+}
+
+
+func (c *coder) appendCode(dst []byte, cv *ComplexValue) ([]byte, error) {
+    writer := appendWriters.Get().(*appendWriter)
+    writer.buffer = dst
+
+    err := c.code(writer, cv)
+
+    result := writer.buffer
+    writer.buffer = nil
+
+    appendWriters.Put(writer)
+
+    if err != nil {
+        return dst, err
+    }
+
+    return result, nil
+}
+
+
+func (c *coder) code(w io.Writer, cv *ComplexValue) error {
+    var result    string
+    var err       error
+
+    if cv.source != nil && (c.caches != nil ||
+                            cv.generation != c.corgi.currentGeneration()) {
+
+        // the folded values may be changed, or be got with another context
+        // than the session's
+        cv = cv.source
+    }
 
     pos := 0
 
@@ -228,53 +727,154 @@ func (corgi *Corgi) Code(cv *ComplexValue) (string, error) {
             break
         }
 
-        code := cv.code[pos]
+        if err = c.stopped(); err != nil {
+            return err
+        }
+
+        code := &cv.code[pos]
         pos++
 
-        if code.kind == SCRIPT_PLAIN {
-            if n, err := buffer.WriteString(code.data); err != nil {
-                return "", err
+        name := code.data
 
-            } else if n != len(code.data) {
-                return "", errors.New("incomplete written operation")
+        if code.name != nil {
+            if name, err = c.indirectName(code); err != nil {
+                return err
             }
-
-            continue
         }
 
-        if code.kind == SCRIPT_CAPTURE {
-            if corgi.Group == nil {
-                return "", errors.New("empty capture group")
+        switch (code.kind) {
+
+        case SCRIPT_PLAIN:
+            result = code.data
+
+        case SCRIPT_CAPTURE:
+            if result, err = c.captureGet(code.ref, name); err != nil {
+                return err
+            }
+
+        case SCRIPT_OPERATOR:
+            if result, err = c.operatorGet(code, name); err != nil {
+                return err
+            }
+
+        case SCRIPT_INDIRECT:
+            if result, err = c.indirectGet(name); err != nil {
+                return err
             }
 
-            n, _ := strconv.Atoi(code.data)
-            if n >= len(corgi.Group) {
-                return "", errors.New("too large capture number")
+        case SCRIPT_FUNCTION:
+            if result, err = c.functionCall(code); err != nil {
+                return err
+            }
 
-            } else {
-                if c, err := buffer.WriteString(corgi.Group[n]); err != nil {
-                    return "", err
+        case SCRIPT_ARITHMETIC:
+            n, err := c.arithmeticValue(code.arithmetic)
+            if err != nil {
+                return err
+            }
 
-                } else if c != len(corgi.Group[n]) {
-                    return "", errors.New("incomplete written operation")
-                }
+            result = strconv.FormatInt(n, 10)
+
+        case SCRIPT_CONDITION:
+            if err = c.conditionCode(w, code); err != nil {
+                return err
             }
 
             continue
+
+        default:
+            if result, err = c.variableGet(code.ref, name); err != nil {
+                return err
+            }
         }
 
-        if result, err := corgi.variableGet(code.data); err != nil {
-            return "", err
+        for _, filter := range code.filters {
+            if result, err = filter(result); err != nil {
+                return err
+            }
+        }
+
+        if code.escape != nil {
+            err = code.escapeTo(w, result)
+
+        } else if code.format != nil {
+            err = code.format.write(w, result)
 
         } else {
-            if n, err := buffer.WriteString(result); err != nil {
-                return "", err
+            err = writeString(w, result)
+        }
 
-            } else if n != len(result) {
-                return "", errors.New("incomplete written operation")
-            }
+        if err != nil {
+            return err
         }
     }
 
-    return buffer.String(), nil
+    return nil
+}
+
+
+// codeWriter counts the bytes written to w.
+type codeWriter struct {
+    w    io.Writer
+    n    int64
+}
+
+
+func (writer *codeWriter) Write(p []byte) (int, error) {
+    n, err := writer.w.Write(p)
+    writer.n += int64(n)
+
+    return n, err
+}
+
+
+func (writer *codeWriter) WriteString(s string) (int, error) {
+    n, err := io.WriteString(writer.w, s)
+    writer.n += int64(n)
+
+    return n, err
+}
+
+
+// appendWriter appends the written bytes to buffer, the instances are pooled
+// so that AppendCode does not allocate.
+type appendWriter struct {
+    buffer    []byte
+}
+
+
+var appendWriters = sync.Pool {
+    New : func() interface{} {
+        return new(appendWriter)
+    },
+}
+
+
+func (writer *appendWriter) Write(p []byte) (int, error) {
+    writer.buffer = append(writer.buffer, p...)
+
+    return len(p), nil
+}
+
+
+func (writer *appendWriter) WriteString(s string) (int, error) {
+    writer.buffer = append(writer.buffer, s...)
+
+    return len(s), nil
+}
+
+
+func writeString(w io.Writer, s string) error {
+    if s == "" {
+        return nil
+    }
+
+    if n, err := io.WriteString(w, s); err != nil {
+        return err
+
+    } else if n != len(s) {
+        return errors.New("incomplete written operation")
+    }
+
+    return nil
 }
@@ -5,6 +5,8 @@ package corgi
 import (
     "os"
     "fmt"
+    "bytes"
+    "errors"
     "regexp"
     "testing"
 )
@@ -44,7 +46,7 @@ func testParseFailed(t *testing.T) {
     }
 
     plain = "hello ${hostname$pid"
-    errorReason = "\"}\" for variable \"hostname\" is missing"
+    errorReason = "unexpected end of string, \"}\" is missing"
 
     if _, err := c.Parse(plain); err == nil {
         t.Fatal("unexpected successful parsing")
@@ -156,8 +158,213 @@ func testParseCapture(t *testing.T) {
 }
 
 
+// shortWriter accepts at most limit bytes, then either fails with err or
+// reports a short write without an error if err is nil.
+type shortWriter struct {
+    buffer    bytes.Buffer
+    limit     int
+    err       error
+}
+
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+    if w.buffer.Len() + len(p) <= w.limit {
+        return w.buffer.Write(p)
+    }
+
+    n, _ := w.buffer.Write(p[:w.limit - w.buffer.Len()])
+
+    return n, w.err
+}
+
+
+func testParseCodeTo(t *testing.T) {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    text := "name: ${name:>8}, $?{gender}{gender: $gender}, weight: $weight"
+
+    cv, err := c.Parse(text)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    expected, err := c.Code(cv)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    var buffer    bytes.Buffer
+
+    if n, err := c.CodeTo(&buffer, cv); err != nil {
+        t.Fatal(err.Error())
+
+    } else if buffer.String() != expected || n != int64(len(expected)) {
+        t.Fatalf("incorrect value, expected \"%s\"(%d) but seen \"%s\"(%d)",
+                 expected, len(expected), buffer.String(), n)
+    }
+
+    // the writer without the WriteString method
+    w := &shortWriter { limit : len(expected) }
+
+    if n, err := c.CodeTo(w, cv); err != nil {
+        t.Fatal(err.Error())
+
+    } else if w.buffer.String() != expected || n != int64(len(expected)) {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"",
+                 expected, w.buffer.String())
+    }
+
+    failure := errors.New("no space left on device")
+    w = &shortWriter { limit : 10, err : failure }
+
+    if n, err := c.CodeTo(w, cv); err != failure {
+        t.Fatalf("unexpected failure reason: %v", err)
+
+    } else if n != 10 || w.buffer.String() != expected[:10] {
+        t.Fatalf("incorrect written data \"%s\"(%d)", w.buffer.String(), n)
+    }
+
+    w = &shortWriter { limit : 20 }
+    errorReason := "incomplete written operation"
+
+    if n, err := c.CodeTo(w, cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason || n != 20 {
+        t.Fatalf("unknown failure reason: %s(%d)", err.Error(), n)
+    }
+}
+
+
 func TestParse(t *testing.T) {
     testParseFailed(t)
     testParseComplex(t)
     testParseCapture(t)
+    testParseCodeTo(t)
+    testParseAppendCode(t)
+}
+
+
+func variableGetConstant(value *VariableValue, _ interface{},
+                         name string) error {
+
+    value.NotFound = false
+    value.Cacheable = true
+    value.Value = name + "-value"
+
+    return nil
+}
+
+
+func newAppendCodeCorgi(tb testing.TB) (*Corgi, *ComplexValue) {
+    c, err := New()
+    if err != nil {
+        tb.Fatal("failed to create corgi instance failed")
+    }
+
+    for _, name := range []string { "remote_addr", "method", "uri", "status" } {
+        variable := &Variable {
+            Name : name,
+            Get  : variableGetConstant,
+        }
+
+        if err := c.RegisterNewVariable(variable); err != nil {
+            tb.Fatalf("failed to register new variable: %s", err.Error())
+        }
+    }
+
+    text := `$remote_addr - "$method ${uri:<32}" ${status:>4} $1 $$`
+
+    cv, err := c.Parse(text)
+    if err != nil {
+        tb.Fatal(err.Error())
+    }
+
+    c.Group = []string { "GET /", "GET" }
+
+    return c, cv
+}
+
+
+func testParseAppendCode(t *testing.T) {
+    c, cv := newAppendCodeCorgi(t)
+
+    expected, err := c.Code(cv)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    dst := []byte("log: ")
+
+    dst, err = c.AppendCode(dst, cv)
+    if err != nil {
+        t.Fatal(err.Error())
+
+    } else if string(dst) != "log: " + expected {
+        t.Fatalf("incorrect value, expected \"log: %s\" but seen \"%s\"",
+                 expected, dst)
+    }
+
+    allocs := testing.AllocsPerRun(100, func() {
+        if dst, err = c.AppendCode(dst[:0], cv); err != nil {
+            t.Fatal(err.Error())
+        }
+    })
+
+    if allocs != 0 {
+        t.Fatalf("unexpected %v allocations per AppendCode", allocs)
+    }
+
+    cv, err = c.Parse("$remote_addr $2")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    dst = []byte("log: ")
+
+    if result, err := c.AppendCode(dst, cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if string(result) != "log: " {
+        t.Fatalf("dst is changed to \"%s\"", result)
+    }
+}
+
+
+func BenchmarkAppendCode(b *testing.B) {
+    c, cv := newAppendCodeCorgi(b)
+
+    dst := make([]byte, 0, 256)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        var err error
+
+        if dst, err = c.AppendCode(dst[:0], cv); err != nil {
+            b.Fatal(err.Error())
+        }
+    }
+}
+
+
+func BenchmarkCode(b *testing.B) {
+    c, cv := newAppendCodeCorgi(b)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        if _, err := c.Code(cv); err != nil {
+            b.Fatal(err.Error())
+        }
+    }
 }
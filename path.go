@@ -0,0 +1,173 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "strings"
+)
+
+
+const (
+    PATH_DOT      = '.'
+    PATH_LBRACKET = '['
+    PATH_RBRACKET = ']'
+)
+
+
+// VariableGetPathHandler gets the value of a path of variable, e.g.
+// ${req.header.host}, the path is the segments after the variable name,
+// like []string{"header", "host"}, the index is passed as the decimal
+// string, e.g. "2" for ${args[2]}.
+type VariableGetPathHandler func(value *VariableValue, ctx interface{},
+                                 name string, path []string) error
+
+
+func isDigit(ch byte) bool {
+    return ch >= '0' && ch <= '9'
+}
+
+
+// isValidPath reports whether name is either a valid name or a valid name
+// followed by the segments, like ".field" and "[n]".
+func isValidPath(name string) bool {
+    i := strings.IndexAny(name, ".[")
+    if i < 0 {
+        return isValidName(name)
+    }
+
+    if isValidName(name[:i]) == false {
+        return false
+    }
+
+    for i < len(name) {
+        var j int
+
+        if name[i] == PATH_DOT {
+            for j = i + 1; j < len(name); j++ {
+                if isValidVariableCharacter(rune(name[j])) == false {
+                    break
+                }
+            }
+
+            if j == i + 1 {
+                return false
+            }
+
+            i = j
+            continue
+        }
+
+        if name[i] != PATH_LBRACKET {
+            return false
+        }
+
+        j = i + 1
+
+        for j < len(name) && isDigit(name[j]) {
+            j++
+        }
+
+        if j == i + 1 || j == len(name) || name[j] != PATH_RBRACKET {
+            return false
+        }
+
+        i = j + 1
+    }
+
+    return true
+}
+
+
+// splitPath splits the valid path to the variable name and the segments,
+// the segments is nil if there is no path.
+func splitPath(name string) (string, []string) {
+    var path []string
+
+    i := strings.IndexAny(name, ".[")
+    if i < 0 {
+        return name, nil
+    }
+
+    root := name[:i]
+
+    for i < len(name) {
+        // skips the "." or "["
+        i++
+
+        j := strings.IndexAny(name[i:], ".[]")
+        if j < 0 {
+            j = len(name) - i
+        }
+
+        path = append(path, name[i:i + j])
+
+        i += j
+        if i < len(name) && name[i] == PATH_RBRACKET {
+            i++
+        }
+    }
+
+    return root, path
+}
+
+
+// parsePath parses the variable name and its path, like "args[2].name", a
+// "." which is not followed by a name character is not consumed.
+func (p *parser) parsePath() string {
+    from := p.pos
+
+    if p.parseName() == "" {
+        return ""
+    }
+
+    for p.pos < len(p.text) {
+        ch := p.text[p.pos]
+
+        if ch == PATH_DOT && p.pos + 1 < len(p.text) &&
+           isValidVariableCharacter(rune(p.text[p.pos + 1])) {
+
+            p.pos++
+            p.parseName()
+
+            continue
+        }
+
+        if ch == PATH_LBRACKET {
+            j := p.pos + 1
+
+            for j < len(p.text) && isDigit(p.text[j]) {
+                j++
+            }
+
+            if j > p.pos + 1 && j < len(p.text) && p.text[j] == PATH_RBRACKET {
+                p.pos = j + 1
+                continue
+            }
+        }
+
+        break
+    }
+
+    return p.text[from:p.pos]
+}
+
+
+// parsePathPart parses a literal part of the indirect name, which can
+// contain the path characters, e.g. the ".host" in ${req.${header}.host}.
+func (p *parser) parsePathPart() string {
+    from := p.pos
+
+    for p.pos < len(p.text) && p.atRBracket() == false {
+        ch := p.text[p.pos]
+
+        if isValidVariableCharacter(rune(ch)) == false && ch != PATH_DOT &&
+           ch != PATH_LBRACKET && ch != PATH_RBRACKET {
+
+            break
+        }
+
+        p.pos++
+    }
+
+    return p.text[from:p.pos]
+}
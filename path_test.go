@@ -0,0 +1,206 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "testing"
+)
+
+
+var pathDocument map[string]interface{} = map[string]interface{} {
+    "header" : map[string]interface{} {
+        "host" : "example.com",
+        "port" : "8080",
+    },
+
+    "args"  : []interface{} { "corgi", "-c", "corgi.conf" },
+    "empty" : "",
+}
+
+
+// variablePathGet walks the document with the path.
+func variablePathGet(value *VariableValue, ctx interface{}, name string,
+                     path []string) error {
+
+    var node interface{} = pathDocument
+
+    for _, segment := range path {
+        switch v := node.(type) {
+        case map[string]interface{}:
+            node = v[segment]
+
+        case []interface{}:
+            n, err := strconv.Atoi(segment)
+            if err != nil || n >= len(v) {
+                return fmt.Errorf("bad index \"%s\"", segment)
+            }
+
+            node = v[n]
+
+        default:
+            node = nil
+        }
+    }
+
+    s, ok := node.(string)
+
+    value.Value = s
+    value.NotFound = ok == false
+    value.Cacheable = true
+
+    return nil
+}
+
+
+func newPathCorgi(t *testing.T) *Corgi {
+    c := newOperatorCorgi(t)
+
+    err := c.RegisterNewVariables([]*Variable {
+        &Variable {
+            Name    : "req",
+            Get     : variableUnknownFirst,
+            GetPath : variablePathGet,
+            Flags   : VARIABLE_CHANGEABLE,
+        },
+
+        &Variable {
+            Name    : "doc_",
+            Get     : variableUnknownSecond,
+            GetPath : variablePathGet,
+            Flags   : VARIABLE_UNKNOWN,
+        },
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testPathValue(t *testing.T) {
+    c := newPathCorgi(t)
+
+    c.Group = []string { "", "header" }
+
+    cases := map[string]string {
+        "${req}"                          : "first",
+        "${req.header.host}"              : "example.com",
+        "${req.header.port:>6}"           : "  8080",
+        "${req.args[2]}"                  : "corgi.conf",
+        "${req.args.0|upper}"             : "CORGI",
+        "${doc_x.header.host}"            : "example.com",
+        "${req.header.none:-none}"        : "none",
+        "${req.empty:+empty}"             : "",
+        "$?{req.header.host}{yes}{no}"    : "yes",
+        "$?{req.header.none}{yes}{no}"    : "no",
+        "${req.header.host%.com}"         : "example",
+        "$req.header"                     : "first.header",
+        "${req.${1}.host}"                : "example.com",
+        "${req.${1}[0]:-x}"               : "x",
+        "${req.args[1${set:0:0}]}"        : "-c",
+    }
+
+    for text, expected := range cases {
+        if data := parse(t, c, text); data != expected {
+            t.Fatalf("incorrect value of \"%s\", expected \"%s\" but seen \"%s\"",
+                     text, expected, data)
+        }
+    }
+
+    cases = map[string]string {
+        "${set.x}"            : "variable \"set\" has no paths",
+        "${1.x}"              : "capture group \"1\" has no paths",
+        "${xxx.y}"            : "unknown variable \"xxx\"",
+        "${req.x:=y}"         : "cannot assign to path \"req.x\"",
+        "${req.}"             : "\"}\" for variable \"req\" is missing",
+        "${req[x]}"           : "\"}\" for variable \"req\" is missing",
+    }
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+
+    cases = map[string]string {
+        "${req.args[9]}"      : "bad index \"9\"",
+        "${req.header.none}"  : "vlaue of variable \"req.header.none\" not found",
+        "${req.${null}[x]}"   : "invalid variable name \"req.[x]\" from \"req.${null}[x]\"",
+        "${req${set:0:0}.}"   : "invalid variable name \"req.\" from \"req${set:0:0}.\"",
+    }
+
+    for text, errorReason := range cases {
+        cv, err := c.Parse(text)
+        if err != nil {
+            t.Fatalf("failed to parse \"%s\": %s", text, err.Error())
+        }
+
+        if _, err := c.Code(cv); err == nil {
+            t.Fatalf("unexpected successful coding of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testPathCache(t *testing.T) {
+    c := newPathCorgi(t)
+
+    cv, err := c.Parse("${req.header.host}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if data, _ := c.Code(cv); data != "example.com" {
+        t.Fatalf("incorrect value \"%s\"", data)
+    }
+
+    // the cached paths are flushed when the variable is changed
+    err = c.RegisterNewVariable(&Variable {
+        Name    : "req",
+        Get     : variableUnknownFirst,
+        GetPath : func(value *VariableValue, _ interface{}, _ string,
+                       path []string) error {
+
+            value.Value = strings.Join(path, "/")
+            value.Cacheable = true
+
+            return nil
+        },
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variable: %s", err.Error())
+    }
+
+    if data, _ := c.Code(cv); data != "header/host" {
+        t.Fatalf("incorrect value \"%s\"", data)
+    }
+}
+
+
+func TestPath(t *testing.T) {
+    if isValidPath("a.b[1].c_2") == false || isValidPath("a.") ||
+       isValidPath("a[]") || isValidPath("a[1") || isValidPath(".a") {
+
+        t.Fatal("incorrect path validation")
+    }
+
+    root, path := splitPath("a.b[10].c")
+    if root != "a" || strings.Join(path, ",") != "b,10,c" {
+        t.Fatalf("incorrect path splitting: %s %v", root, path)
+    }
+
+    testPathValue(t)
+    testPathCache(t)
+}
@@ -0,0 +1,140 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "io"
+    "sync"
+    "regexp"
+    "context"
+)
+
+
+// Session is the state of coding for a request, like the request of nginx,
+// it holds the context, the capture groups and the cached values of
+// variables, which live only in the session, while the variables are shared
+// by Corgi.
+// A session is not safe for concurrent use, it should be released after
+// use.
+type Session struct {
+    coder    coder
+}
+
+
+var sessions = sync.Pool {
+    New : func() interface{} {
+        return &Session {
+            coder : coder {
+                caches : make(map[string]*VariableValue, VARIABLE_SLOTS),
+            },
+        }
+    },
+}
+
+
+// NewSession returns a session from the pool, ctx is passed to the handlers
+// of variables and functions.
+func (corgi *Corgi) NewSession(ctx interface{}) *Session {
+    session := sessions.Get().(*Session)
+
+    session.coder.corgi = corgi
+    session.coder.ctx = ctx
+
+    return session
+}
+
+
+// Release puts the session back to the pool, the session and the results of
+// its BindRegexp can't be used after that.
+func (session *Session) Release() {
+    c := &session.coder
+
+    for name, _ := range c.caches {
+        delete(c.caches, name)
+    }
+
+    for i := range c.indexed {
+        c.indexed[i] = nil
+    }
+
+    c.corgi = nil
+    c.ctx = nil
+    c.group = nil
+    c.regexp = nil
+    c.context = nil
+    c.resolving = c.resolving[:0]
+
+    sessions.Put(session)
+}
+
+
+// BindRegexp is like Corgi.BindRegexp, but the capture groups are bound to
+// the session, the named groups are referenced by the names of re.
+func (session *Session) BindRegexp(re *regexp.Regexp, subject string) bool {
+    session.coder.group = re.FindStringSubmatch(subject)
+    session.coder.regexp = re
+
+    return session.coder.group != nil
+}
+
+
+// BindRegexpBytes is like BindRegexp, but subject is a byte slice.
+func (session *Session) BindRegexpBytes(re *regexp.Regexp,
+                                        subject []byte) bool {
+
+    session.coder.group = findSubmatch(re, subject)
+    session.coder.regexp = re
+
+    return session.coder.group != nil
+}
+
+
+// Code is like Corgi.Code, but the context, the capture groups and the
+// cached values of the session are used.
+// A handler may code the templates again with the session, so that the
+// recursive references are detected.
+func (session *Session) Code(cv *ComplexValue) (string, error) {
+    return session.coder.codeString(cv)
+}
+
+
+// CodeContext is like Corgi.CodeContext, but with the session, ctx is used
+// only in this coding.
+// A context-aware handler may code the templates again with the session and
+// the ctx passed to it, so that the recursive references are detected.
+func (session *Session) CodeContext(ctx context.Context,
+                                    cv *ComplexValue) (string, error) {
+
+    c := &session.coder
+
+    previous := c.context
+    depth := len(c.resolving)
+
+    c.context = ctx
+
+    // the coding is not started by a handler of the session
+    if depth == 0 {
+        c.inherit(ctx)
+    }
+
+    data, err := c.codeString(cv)
+
+    c.context = previous
+    c.resolving = c.resolving[:depth]
+
+    return data, err
+}
+
+
+// CodeTo is like Corgi.CodeTo, but with the session.
+func (session *Session) CodeTo(w io.Writer, cv *ComplexValue) (int64, error) {
+    return session.coder.codeTo(w, cv)
+}
+
+
+// AppendCode is like Corgi.AppendCode, but with the session.
+func (session *Session) AppendCode(dst []byte,
+                                   cv *ComplexValue) ([]byte, error) {
+
+    return session.coder.appendCode(dst, cv)
+}
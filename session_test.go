@@ -0,0 +1,350 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "time"
+    "errors"
+    "regexp"
+    "context"
+    "testing"
+)
+
+
+type sessionContext struct {
+    host       string
+    session   *Session
+    cv        *ComplexValue
+    calls      int
+}
+
+
+func variableGetHost(value *VariableValue, ctx interface{}, _ string) error {
+    sc := ctx.(*sessionContext)
+    sc.calls++
+
+    value.NotFound = false
+    value.Cacheable = true
+    value.Value = sc.host
+
+    return nil
+}
+
+
+func variableGetSessionRecursive(value *VariableValue, ctx interface{},
+                                 _ string) error {
+
+    sc := ctx.(*sessionContext)
+
+    result, err := sc.session.Code(sc.cv)
+    if err != nil {
+        return err
+    }
+
+    value.NotFound = false
+    value.Value = result
+
+    return nil
+}
+
+
+// variableGetSessionContext codes the template again with the session and
+// the context passed to the handler.
+func variableGetSessionContext(ctx context.Context, value *VariableValue,
+                               data interface{}, _ string) error {
+
+    sc := data.(*sessionContext)
+
+    result, err := sc.session.CodeContext(ctx, sc.cv)
+    if err != nil {
+        return err
+    }
+
+    value.NotFound = false
+    value.Value = result
+
+    return nil
+}
+
+
+func newSessionCorgi(t *testing.T) *Corgi {
+    c, err := New()
+    if err != nil {
+        t.Fatal("failed to create corgi instance failed")
+    }
+
+    variables := []*Variable {
+        &Variable {
+            Name : "http_host",
+            Get  : variableGetHost,
+        },
+
+        &Variable {
+            Name : "self",
+            Get  : variableGetSessionRecursive,
+        },
+
+        &Variable {
+            Name       : "slow",
+            GetContext : variableGetSlow,
+        },
+
+        &Variable {
+            Name       : "self_context",
+            GetContext : variableGetSessionContext,
+        },
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func sessionCode(t *testing.T, session *Session, cv *ComplexValue,
+                 expected string) {
+
+    if data, err := session.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 data)
+    }
+}
+
+
+func testSessionCache(t *testing.T) {
+    c := newSessionCorgi(t)
+
+    cv, err := c.Parse("$http_host/${http_host}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    first := &sessionContext { host : "a.com" }
+    second := &sessionContext { host : "b.com" }
+
+    s1 := c.NewSession(first)
+    s2 := c.NewSession(second)
+
+    sessionCode(t, s1, cv, "a.com/a.com")
+    sessionCode(t, s2, cv, "b.com/b.com")
+    sessionCode(t, s1, cv, "a.com/a.com")
+
+    // the value is cached in each session
+    if first.calls != 1 || second.calls != 1 {
+        t.Fatalf("unexpected calls of get handler: %d, %d", first.calls,
+                 second.calls)
+    }
+
+    // the value doesn't leak to Corgi or the later sessions
+    c.Context = &sessionContext { host : "c.com" }
+    expectCode(t, c, cv, "c.com/c.com")
+
+    s1.Release()
+    s2.Release()
+
+    third := &sessionContext { host : "d.com" }
+
+    s3 := c.NewSession(third)
+    defer s3.Release()
+
+    sessionCode(t, s3, cv, "d.com/d.com")
+
+    // the folded value of Corgi is not coded by the session
+    folded := c.Optimize(cv)
+
+    expectCode(t, c, folded, "c.com/c.com")
+    sessionCode(t, s3, folded, "d.com/d.com")
+
+    var dst []byte
+
+    if dst, err = s3.AppendCode(dst, cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if string(dst) != "d.com/d.com" || third.calls != 1 {
+        t.Fatalf("incorrect value \"%s\"(%d calls)", dst, third.calls)
+    }
+}
+
+
+func testSessionCapture(t *testing.T) {
+    c := newSessionCorgi(t)
+
+    if c.BindRegexp(regexp.MustCompile("^(?P<user>\\w+)$"), "alex") == false {
+        t.Fatal("failed to match")
+    }
+
+    cv, err := c.Parse("$1 $user")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    session := c.NewSession(nil)
+    defer session.Release()
+
+    // the group "user" is the second one in this regular expression
+    re := regexp.MustCompile("^(\\w+)@(?P<user>\\w+)$")
+
+    if session.BindRegexp(re, "x@bob") == false {
+        t.Fatal("failed to match")
+    }
+
+    sessionCode(t, session, cv, "x bob")
+    expectCode(t, c, cv, "alex alex")
+
+    if session.BindRegexpBytes(re, []byte("no match")) {
+        t.Fatal("unexpected match")
+    }
+
+    if _, err := session.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "empty capture group" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+}
+
+
+func testSessionRecursive(t *testing.T) {
+    c := newSessionCorgi(t)
+
+    cv, err := c.Parse("${self}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    ctx := &sessionContext { cv : cv }
+
+    ctx.session = c.NewSession(ctx)
+    defer ctx.session.Release()
+
+    errorReason := "variable \"self\" is referenced recursively"
+
+    if _, err := ctx.session.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the resolving state must be restored
+    ctx.host = "a.com"
+
+    cv, err = c.Parse("$http_host")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    sessionCode(t, ctx.session, cv, "a.com")
+}
+
+
+func testSessionContext(t *testing.T) {
+    c := newSessionCorgi(t)
+
+    cv, err := c.Parse("[${slow}]")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    session := c.NewSession(time.Millisecond)
+
+    if data, err := session.CodeContext(context.Background(), cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "[1ms]" {
+        t.Fatalf("incorrect value, expected \"[1ms]\" but seen \"%s\"", data)
+    }
+
+    session.Release()
+
+    // the handler waits until the deadline is exceeded
+    session = c.NewSession(nil)
+    defer session.Release()
+
+    ctx, cancel := context.WithTimeout(context.Background(),
+                                       10 * time.Millisecond)
+    defer cancel()
+
+    _, err = session.CodeContext(ctx, cv)
+    if errors.Is(err, context.DeadlineExceeded) == false {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    // ctx is not used by the later codings of the session
+    cv, err = c.Parse("plain")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    sessionCode(t, session, cv, "plain")
+
+    // the handler codes with the session and the context
+    cv, err = c.Parse("${self_context}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    sc := &sessionContext { cv : cv }
+
+    sc.session = c.NewSession(sc)
+    defer sc.session.Release()
+
+    errorReason := "variable \"self_context\" is referenced recursively"
+
+    if _, err := sc.session.CodeContext(context.Background(), cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    // the handler of Corgi codes with the session
+    if _, err := c.CodeWith(cv, sc, nil); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != errorReason {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    if len(sc.session.coder.resolving) != 0 {
+        t.Fatal("the resolving state is not restored")
+    }
+}
+
+
+func TestSession(t *testing.T) {
+    testSessionCache(t)
+    testSessionCapture(t)
+    testSessionRecursive(t)
+    testSessionContext(t)
+}
+
+
+func BenchmarkSession(b *testing.B) {
+    c, cv := newAppendCodeCorgi(b)
+
+    dst := make([]byte, 0, 256)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+
+    for i := 0; i < b.N; i++ {
+        var err error
+
+        session := c.NewSession(nil)
+
+        // binds the groups of Corgi without matching
+        session.coder.group = c.Group
+
+        if dst, err = session.AppendCode(dst[:0], cv); err != nil {
+            b.Fatal(err.Error())
+        }
+
+        session.Release()
+    }
+}
@@ -0,0 +1,158 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+)
+
+
+// Syntax describles the delimiters of variables.
+// Preface, the leading sequence of variables, e.g. "$".
+// LBracket, the left bracket, which follows the preface, e.g. "{".
+// RBracket, the right bracket, which closes the bracketed variables.
+//
+// Either Preface or LBracket can be empty, in such a case all variables
+// must be bracketed, e.g. "{{name}}" or "@name@".
+type Syntax struct {
+    Preface   string
+    LBracket  string
+    RBracket  string
+}
+
+
+// Option configures the Corgi instance, see New.
+type Option func(corgi *Corgi) error
+
+
+var (
+    // DefaultSyntax, like $name and ${name}
+    DefaultSyntax Syntax = Syntax {
+        string(VARIABLE_PREFACE),
+        string(VARIABLE_LBRACKET),
+        string(VARIABLE_RBRACKET),
+    }
+
+    // PercentSyntax, like %name and %{name}
+    PercentSyntax Syntax = Syntax { "%", "{", "}" }
+
+    // MustacheSyntax, like {{name}}
+    MustacheSyntax Syntax = Syntax { "", "{{", "}}" }
+
+    // AtSyntax, like @name@
+    AtSyntax Syntax = Syntax { "@", "", "@" }
+)
+
+
+func isValidDelimiter(delimiter string) bool {
+    for _, ch := range delimiter {
+        if isValidVariableCharacter(ch) || ch == ' ' {
+            return false
+        }
+
+        if strings.ContainsRune(":|?!()\",\\", ch) {
+            return false
+        }
+    }
+
+    return true
+}
+
+
+func (syntax *Syntax) check() error {
+    if syntax.Preface == "" && syntax.LBracket == "" {
+        return errors.New("invalid syntax, empty preface and left bracket")
+    }
+
+    if syntax.RBracket == "" {
+        return errors.New("invalid syntax, empty right bracket")
+    }
+
+    for _, delimiter := range []string { syntax.Preface, syntax.LBracket,
+                                         syntax.RBracket } {
+
+        if isValidDelimiter(delimiter) == false {
+            return fmt.Errorf("invalid syntax, bad delimiter \"%s\"", delimiter)
+        }
+    }
+
+    return nil
+}
+
+
+// opener returns the sequence which starts a variable.
+func (syntax *Syntax) opener() string {
+    if syntax.Preface != "" {
+        return syntax.Preface
+    }
+
+    return syntax.LBracket
+}
+
+
+// bare reports whether the unbracketed variables are allowed.
+func (syntax *Syntax) bare() bool {
+    return syntax.Preface != "" && syntax.LBracket != ""
+}
+
+
+// section returns the brackets of the conditional sections.
+func (syntax *Syntax) section() (string, string) {
+    if syntax.LBracket == "" {
+        return string(VARIABLE_LBRACKET), string(VARIABLE_RBRACKET)
+    }
+
+    return syntax.LBracket, syntax.RBracket
+}
+
+
+// WithSyntax lets the Corgi instance use the syntax, rather than the
+// DefaultSyntax.
+func WithSyntax(syntax Syntax) Option {
+    return func(corgi *Corgi) error {
+        if err := syntax.check(); err != nil {
+            return err
+        }
+
+        corgi.syntax = syntax
+
+        return nil
+    }
+}
+
+
+func (p *parser) hasPrefix(s string) bool {
+    return s != "" && strings.HasPrefix(p.text[p.pos:], s)
+}
+
+
+func (p *parser) atOpener() bool {
+    return p.hasPrefix(p.syntax.opener())
+}
+
+
+func (p *parser) atRBracket() bool {
+    return p.hasPrefix(p.syntax.RBracket)
+}
+
+
+// errUnexpectedEnd reports the innermost unclosed bracket.
+func (p *parser) errUnexpectedEnd() error {
+    err := fmt.Errorf("unexpected end of string, \"%s\" is missing",
+                      p.syntax.RBracket)
+
+    return p.fail(newParseError(ErrUnterminated, err), p.open.from,
+                  p.open.to)
+}
+
+
+// errMissingRBracket reports the character where the right bracket is
+// expected.
+func (p *parser) errMissingRBracket(name string) error {
+    err := fmt.Errorf("\"%s\" for variable \"%s\" is missing",
+                      p.syntax.RBracket, name)
+
+    return p.fail(newParseError(ErrUnterminated, err), p.pos, p.pos)
+}
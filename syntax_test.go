@@ -0,0 +1,191 @@
+// Copyright (C) Alex Zhang
+
+package corgi
+
+import (
+    "os"
+    "fmt"
+    "regexp"
+    "testing"
+)
+
+
+func newSyntaxCorgi(t *testing.T, syntax Syntax) *Corgi {
+    c, err := New(WithSyntax(syntax))
+    if err != nil {
+        t.Fatalf("failed to create corgi instance: %s", err.Error())
+    }
+
+    if err := c.RegisterNewVariables(variables); err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    return c
+}
+
+
+func testSyntaxFailed(t *testing.T, syntax Syntax, cases map[string]string) {
+    c := newSyntaxCorgi(t, syntax)
+
+    for text, errorReason := range cases {
+        if _, err := c.Parse(text); err == nil {
+            t.Fatalf("unexpected successful parsing of \"%s\"", text)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
+
+
+func testSyntaxComplex(t *testing.T, syntax Syntax, text, expected string) {
+    c := newSyntaxCorgi(t, syntax)
+
+    hostname, err := os.Hostname()
+    if err != nil {
+        t.Fatalf("failed to get hostname: %s", err.Error())
+    }
+
+    expected = fmt.Sprintf(expected, hostname)
+
+    plain := parse(t, c, text)
+    if plain != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"",
+                 expected, plain)
+    }
+}
+
+
+func testSyntaxCapture(t *testing.T, syntax Syntax, text string) {
+    c := newSyntaxCorgi(t, syntax)
+
+    regex := regexp.MustCompile("(\\d+) sheep in (.*)")
+    c.Group = regex.FindStringSubmatch("1234 sheep in The North Pole")
+
+    expected := "Hello, there are 1234 sheep in The North Pole"
+
+    if plain := parse(t, c, text); plain != expected {
+        t.Fatalf("incorrect value, expected \"%s\" but seen \"%s\"", expected,
+                 plain)
+    }
+}
+
+
+func TestSyntaxPercent(t *testing.T) {
+    testSyntaxFailed(t, PercentSyntax, map[string]string {
+        "hello %"               : "invalid variable name",
+        "hello %hostname%"      : "invalid variable name",
+        "hello %{hostname"      : "unexpected end of string, \"}\" is missing",
+        "hello %{hostname x}"   : "\"}\" for variable \"hostname\" is missing",
+        "hello %pP0"            : "unknown variable \"pP0\"",
+        "hello %{pP0}%%"        : "unknown variable \"pP0\"",
+    })
+
+    testSyntaxComplex(t, PercentSyntax,
+        `Hello, This is %{name}, percent is %%, Host is %hostname, `+
+        `${name} and $gender are plain, %?{!gender}{}{gender is %gender}, `+
+        `%{name|upper:>5}, %upper(%name), %{%{name:0:0}name}`,
+
+        `Hello, This is alex, percent is %%, Host is %s, `+
+        `${name} and $gender are plain, gender is male, `+
+        ` ALEX, ALEX, alex`)
+
+    testSyntaxCapture(t, PercentSyntax,
+                      "Hello, there are %1 sheep in %{2}")
+}
+
+
+func TestSyntaxMustache(t *testing.T) {
+    testSyntaxFailed(t, MustacheSyntax, map[string]string {
+        "hello {{"              : "unexpected end of string, \"}}\" is missing",
+        "hello {{}}"            : "invalid variable name",
+        "hello {{hostname"      : "unexpected end of string, \"}}\" is missing",
+        "hello {{hostname}"     : "\"}}\" for variable \"hostname\" is missing",
+        "hello {{pP0}}"         : "unknown variable \"pP0\"",
+    })
+
+    testSyntaxComplex(t, MustacheSyntax,
+        `Hello, This is {{name}}, braces are {{{{, Host is {{hostname}}, `+
+        `${name}, $gender and {name} are plain, `+
+        `{{?gender}}{{gender is {{gender}}}}, `+
+        `{{name|upper:>5}}, {{upper({{name}})}}`,
+
+        `Hello, This is alex, braces are {{, Host is %s, `+
+        `${name}, $gender and {name} are plain, `+
+        `gender is male, `+
+        ` ALEX, ALEX`)
+
+    testSyntaxCapture(t, MustacheSyntax,
+                      "Hello, there are {{1}} sheep in {{2}}")
+}
+
+
+func TestSyntaxAt(t *testing.T) {
+    testSyntaxFailed(t, AtSyntax, map[string]string {
+        "hello @"               : "unexpected end of string, \"@\" is missing",
+        "hello @@@"             : "unexpected end of string, \"@\" is missing",
+        "hello @hostname"       : "unexpected end of string, \"@\" is missing",
+        "hello @hostname x@"    : "\"@\" for variable \"hostname\" is missing",
+        "hello @pP0@"           : "unknown variable \"pP0\"",
+    })
+
+    testSyntaxComplex(t, AtSyntax,
+        `Hello, This is @name@, mail is alex@@example.com, Host is @hostname@, `+
+        `${name} and $gender are plain, @?gender@{gender is @gender@}, `+
+        `@name|upper:>5@, @upper(@name@)@`,
+
+        `Hello, This is alex, mail is alex@example.com, Host is %s, `+
+        `${name} and $gender are plain, gender is male, `+
+        ` ALEX, ALEX`)
+
+    testSyntaxCapture(t, AtSyntax,
+                      "Hello, there are @1@ sheep in @2@")
+}
+
+
+// TestSyntaxParseAll recovers from the unclosed references, whose nested
+// openers are skipped.
+func TestSyntaxParseAll(t *testing.T) {
+    var tests = []struct {
+        syntax  Syntax
+        texts   []string
+    } {
+        { DefaultSyntax, []string { "${]${", "${upper${", "${name}${x${",
+                                    "$?{x${", "$upper(${" } },
+        { PercentSyntax, []string { "%{]%{", "%{upper%{", "%{name}%{x%{",
+                                    "%?{x%{", "%upper(%{" } },
+        { MustacheSyntax, []string { "{{]{{", "{{upper{{", "{{name}}{{x{{",
+                                     "{{?x{{", "{{upper({{" } },
+        { AtSyntax, []string { "@]@", "@upper@x", "@name@@x@y",
+                               "@?x@@", "@upper(@" } },
+    }
+
+    for _, test := range tests {
+        c := newSyntaxCorgi(t, test.syntax)
+
+        for _, text := range test.texts {
+            if _, err := c.ParseAll(text); err == nil {
+                t.Fatalf("unexpected successful parsing of \"%s\"", text)
+            }
+        }
+    }
+}
+
+
+func TestSyntaxInvalid(t *testing.T) {
+    cases := map[string]Syntax {
+        "invalid syntax, empty preface and left bracket" : Syntax { "", "", "}" },
+        "invalid syntax, empty right bracket"            : Syntax { "$", "{", "" },
+        "invalid syntax, bad delimiter \"a\""            : Syntax { "a", "{", "}" },
+        "invalid syntax, bad delimiter \"|\""            : Syntax { "$", "{", "|" },
+    }
+
+    for errorReason, syntax := range cases {
+        if _, err := New(WithSyntax(syntax)); err == nil {
+            t.Fatalf("unexpected successful creating with syntax %v", syntax)
+
+        } else if err.Error() != errorReason {
+            t.Fatalf("unknown failure reason: %s", err.Error())
+        }
+    }
+}
@@ -4,7 +4,10 @@ package corgi
 
 import (
     "fmt"
+    "errors"
+    "context"
     "strings"
+    "sync/atomic"
 )
 
 
@@ -18,17 +21,29 @@ const (
 type VariableSetHandler func(value *VariableValue, ctx interface{}, name string) error
 type VariableGetHandler func(value *VariableValue, ctx interface{}, name string) error
 
+// VariableGetContextHandler is like VariableGetHandler, but ctx is the one
+// passed to Corgi.CodeContext, which should be checked by the handler that
+// may block(e.g. calls a service or reads a file), and data is the context
+// of coding, like the ctx of VariableGetHandler.
+type VariableGetContextHandler func(ctx context.Context, value *VariableValue, data interface{}, name string) error
+
 
 // Variable describles a variable.
 // Name, variable's name, when the variable is unknown, it is the fixed prefix.
 // Set, the set handler, which will be invoked when changeing the variable.
 // Get, the get handler, which will be invoked when getting the variable.
+// GetContext, the context-aware get handler, which will be invoked rather
+// than Get if it's not nil.
+// GetPath, the get handler of paths, like ${name.field} and ${name[n]}, the
+// paths of variable are invalid if it is nil.
 // Flags, marks the variable type.
 type Variable struct {
-    Name   string
-    Set    VariableSetHandler
-    Get    VariableGetHandler
-    Flags  uint
+    Name        string
+    Set         VariableSetHandler
+    Get         VariableGetHandler
+    GetContext  VariableGetContextHandler
+    GetPath     VariableGetPathHandler
+    Flags       uint
 }
 
 // VariableValue describles the variable value.
@@ -42,6 +57,8 @@ type VariableValue struct {
 }
 
 
+// validUnknownVariable finds the unknown variable which name starts with,
+// the caller must hold the lock.
 func (corgi *Corgi) validUnknownVariable(name string) *Variable {
     for prefix, variable := range corgi.unknowns {
 
@@ -55,42 +72,121 @@ func (corgi *Corgi) validUnknownVariable(name string) *Variable {
 }
 
 
-func (corgi *Corgi) variableGet(name string) (string, error) {
-    var value     VariableValue
-    var variable *Variable
-    var ok        bool
+// lookupVariable finds the variable which name belongs to, the name passed
+// to its handlers(the floating body for the unknown variable), and its index,
+// which is -1 for the unknown variable.
+func (corgi *Corgi) lookupVariable(name string) (*Variable, string, int) {
+    corgi.lock.RLock()
+    defer corgi.lock.RUnlock()
+
+    if variable, ok := corgi.variables[name]; ok == true {
+        return variable, name, corgi.indexes[name]
+    }
 
-    var varName   string = name
+    if variable := corgi.validUnknownVariable(name); variable != nil {
+        return variable, name[len(variable.Name):], -1
+    }
 
-    if variable, ok = corgi.variables[name]; ok == false {
-        if variable = corgi.validUnknownVariable(name); variable == nil {
-            return "", fmt.Errorf("variable \"%s\" not found", name)
-        }
+    return nil, "", -1
+}
+
+
+// variableValue gets the value of variable, whether the value is found is
+// left to the caller by checking the NotFound field.
+func (c *coder) variableValue(name string) (*VariableValue, error) {
+    root, path := splitPath(name)
+
+    generation := c.corgi.currentGeneration()
+
+    variable, varName, index := c.corgi.lookupVariable(root)
+    if variable == nil {
+        // a late bound variable which is still not registered
+        return nil, fmt.Errorf("unknown variable \"%s\"", root)
+    }
 
-        prefix := len(variable.Name)
-        varName = name[prefix:]
+    if path != nil {
+        // the paths are cached by the names
+        index = -1
+    }
+
+    return c.resolvedValue(variable, varName, name, path, index, generation)
+}
+
+
+// resolvedValue gets the value of the resolved variable, varName is passed to
+// its handlers, name is the full name(including the path) for caching, index
+// is the index of variable for caching, or -1 if it's cached by name,
+// generation is the one when variable is resolved.
+func (c *coder) resolvedValue(variable *Variable, varName string,
+                              name string, path []string, index int,
+                              generation uint64) (*VariableValue, error) {
+
+    if path != nil && variable.GetPath == nil {
+        return nil, fmt.Errorf("variable \"%s\" has no paths", variable.Name)
     }
 
     if (variable.Flags & VARIABLE_NO_CACHEABLE) == 0 {
-        if value, ok := corgi.caches[name]; ok == true {
+        if value, ok := c.cached(index, name, generation); ok == true {
             // hits the cache
-            if value.NotFound == true {
-                return "", fmt.Errorf("vlaue of variable \"%s\" not found",
-                name)
-            }
+            return value, nil
+        }
+    }
 
-            return value.Value, nil
+    // a handler may code the templates which refer to this variable again
+    for _, resolving := range c.resolving {
+        if resolving == name {
+            return nil, fmt.Errorf("variable \"%s\" is referenced recursively",
+                                   name)
         }
     }
 
-    ctx := corgi.Context
+    if len(c.resolving) == VARIABLE_MAX_DEPTH {
+        return nil, errors.New("too deep nested variables")
+    }
 
-    if err := variable.Get(&value, ctx, varName); err != nil {
-        return "", err
+    ctx := c.ctx
+
+    // declared after the cache lookup, so that the hit does not allocate
+    var value     VariableValue
+    var err       error
+
+    c.resolving = append(c.resolving, name)
+
+    if path != nil {
+        err = variable.GetPath(&value, ctx, varName, path)
+
+    } else if variable.GetContext != nil {
+        err = variable.GetContext(c.chained(), &value, ctx, varName)
+
+    } else {
+        err = variable.Get(&value, ctx, varName)
+    }
+
+    c.resolving = c.resolving[:len(c.resolving) - 1]
+
+    // the value is dropped if the coding is cancelled while resolving it
+    if c.context != nil && c.context.Err() != nil {
+        return nil, fmt.Errorf("variable \"%s\": %w", name, c.context.Err())
+    }
+
+    if err != nil {
+        return nil, err
     }
 
     if value.Cacheable {
-        corgi.caches[name] = &value
+        c.cache(index, name, &value, generation)
+    }
+
+    return &value, nil
+}
+
+
+func (c *coder) variableGet(ref *scriptReference,
+                            name string) (string, error) {
+
+    value, err := c.valueOf(ref, name)
+    if err != nil {
+        return "", err
     }
 
     if value.NotFound == true {
@@ -101,67 +197,96 @@ func (corgi *Corgi) variableGet(name string) (string, error) {
 }
 
 
+// variableAssign assigns value to the variable, the Set handler will be
+// invoked if exists, and the value will be cached for the coding so that the
+// later references see it.
+func (c *coder) variableAssign(name string, val string) error {
+    var value *VariableValue = &VariableValue {
+        Value     : val,
+        Cacheable : true,
+        NotFound  : false,
+    }
+
+    variable, varName, index := c.corgi.lookupVariable(name)
+    if variable == nil {
+        return fmt.Errorf("unknown variable \"%s\"", name)
+    }
+
+    if variable.Set != nil {
+        if err := variable.Set(value, c.ctx, varName); err != nil {
+            return err
+        }
+    }
+
+    if (variable.Flags & VARIABLE_NO_CACHEABLE) == 0 {
+        c.assign(index, name, value)
+    }
+
+    return nil
+}
+
+
 // RegisterNewVariable Registers a new variable.
 // The unique param is the variable that caller wants to register.
 // In case of failure, a corresponding error object will be yielded.
 func (corgi *Corgi) RegisterNewVariable(variable *Variable) error {
     var name string = variable.Name
 
+    // whether the cache is flushed by the prefix
+    prefix := variable.Flags & VARIABLE_UNKNOWN != 0
+
+    corgi.lock.Lock()
+    defer corgi.lock.Unlock()
+
     if oldVariable, ok := corgi.variables[name]; ok == true {
 
         if oldVariable.Flags & VARIABLE_CHANGEABLE == 0 {
             return fmt.Errorf("variable \"%s\" already exists", name)
         }
 
-        // flushes the cache
-        delete(corgi.caches, name)
-
-        if variable.Flags & VARIABLE_UNKNOWN == 0 {
-            corgi.variables[name] = variable
+        delete(corgi.variables, name)
 
-        } else {
-            delete(corgi.variables, name)
-            corgi.unknowns[name] = variable
-        }
-
-        return nil
-    }
-
-    // name is actually the prefix
-    if oldVariable, ok := corgi.unknowns[name]; ok == true {
+    } else if oldVariable, ok := corgi.unknowns[name]; ok == true {
+        // name is actually the prefix
 
         if oldVariable.Flags & VARIABLE_CHANGEABLE == 0 {
             return fmt.Errorf("variable \"%s\" already exists", name)
         }
 
-        for key, _ := range corgi.caches {
-
-            // FIXME implements with a more effective way
-            if strings.HasPrefix(key, name) == true {
-
-                // flushes the cache
-                delete(corgi.caches, key)
-            }
-        }
-
-        if variable.Flags & VARIABLE_UNKNOWN == 0 {
-            delete(corgi.unknowns, name)
-            corgi.variables[name] = variable
-
-        } else {
-            corgi.unknowns[name] = variable
-        }
-
-        return nil
+        delete(corgi.unknowns, name)
+        prefix = true
     }
 
     if variable.Flags & VARIABLE_UNKNOWN == 0 {
         corgi.variables[name] = variable
 
+        // the index is kept when the variable is registered again
+        if _, ok := corgi.indexes[name]; ok == false {
+            corgi.indexes[name] = len(corgi.indexes)
+            corgi.growSlots(len(corgi.indexes))
+        }
+
     } else {
         corgi.unknowns[name] = variable
     }
 
+    // invalidates the variables resolved by the parsed templates and the
+    // values of the indexed variables, before the cache is flushed, so that a
+    // value got by the old variable is not cached after that
+    atomic.AddUint64(&corgi.generation, 1)
+
+    // flushes the cache, including the paths, and the names under the prefix
+    corgi.caches.flush(func(key string) bool {
+        if prefix {
+            // FIXME implements with a more effective way
+            return strings.HasPrefix(key, name)
+        }
+
+        root, _ := splitPath(key)
+
+        return root == name
+    })
+
     return nil
 }
 
@@ -185,3 +310,14 @@ func (corgi *Corgi) RegisterNewVariables(variables []*Variable) error {
 func (corgi *Corgi) registerPredefineVariables() error {
     return corgi.RegisterNewVariables(predefineVariables)
 }
+
+
+// WithLateBinding lets Corgi.Parse accept the variables which are not
+// registered yet, they are checked by Corgi.Code, so a template can be
+// parsed before its variables are registered.
+func WithLateBinding() Option {
+    return func(corgi *Corgi) error {
+        corgi.lateBinding = true
+        return nil
+    }
+}
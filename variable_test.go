@@ -424,10 +424,78 @@ func testVariableError(t *testing.T) {
 }
 
 
+func testVariableLateBinding(t *testing.T) {
+    c, err := New(WithLateBinding())
+    if err != nil {
+        t.Fatalf("failed to create corgi instance: %s", err.Error())
+    }
+
+    cv, err := c.Parse("${plugin_a}-$plugin_b-${later_x:-none}-$?{later_y}{y}")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "unknown variable \"plugin_a\"" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    err = c.RegisterNewVariables([]*Variable {
+        &Variable {
+            Name  : "plugin_a",
+            Get   : variableUnknownFirst,
+        },
+
+        &Variable {
+            Name  : "plugin_b",
+            Get   : variableUnknownSecond,
+        },
+
+        &Variable {
+            Name  : "later_",
+            Get   : variableGetError,
+            Flags : VARIABLE_UNKNOWN,
+        },
+    })
+
+    if err != nil {
+        t.Fatalf("failed to register new variables: %s", err.Error())
+    }
+
+    if _, err := c.Code(cv); err == nil {
+        t.Fatal("unexpected successful coding")
+
+    } else if err.Error() != "intentional error" {
+        t.Fatalf("unknown failure reason: %s", err.Error())
+    }
+
+    cv, err = c.Parse("${plugin_a}-$plugin_b")
+    if err != nil {
+        t.Fatalf("failed to parse: %s", err.Error())
+    }
+
+    if data, err := c.Code(cv); err != nil {
+        t.Fatalf("failed to code: %s", err.Error())
+
+    } else if data != "first-second" {
+        t.Fatalf("incorrect value, expected \"first-second\" but seen \"%s\"",
+                 data)
+    }
+
+    // other errors are still reported by Corgi.Parse
+    if _, err := c.Parse("${100}"); err == nil {
+        t.Fatal("unexpected successful parsing")
+    }
+}
+
+
 func TestVariable(t *testing.T) {
     testVariableRegister(t)
     testVariableCache(t)
     testVariableChange(t)
     testVariableValueNotFound(t)
     testVariableError(t)
+    testVariableLateBinding(t)
 }